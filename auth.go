@@ -0,0 +1,131 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errForbiddenIP      = errors.New("client address not allowed")
+	errUnauthorized     = errors.New("missing or invalid credentials")
+	errInvalidSignature = errors.New("missing or invalid request signature")
+	errBodyTooLarge     = errors.New("request body too large to check its signature")
+)
+
+// Authenticate wraps h with the webhook_auth section of the current configuration, so that changes take effect on
+// reload. Requests from addresses outside allowed_cidrs are answered with 403, requests without valid credentials or
+// signature with 401, and signed requests with a body larger than max_body_bytes with 413.
+func Authenticate(reloader *Reloader, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := reloader.Config().WebhookAuth
+		if auth == nil {
+			h(w, r)
+			return
+		}
+		if err := authenticate(auth, w, r); err != nil {
+			var reason string
+			status := http.StatusUnauthorized
+			switch err {
+			case errForbiddenIP:
+				reason, status = "ip", http.StatusForbidden
+			case errUnauthorized:
+				reason = "credentials"
+				if auth.BasicAuth != nil {
+					w.Header().Set("WWW-Authenticate", `Basic realm="jiralert"`)
+				}
+			case errInvalidSignature:
+				reason = "signature"
+			case errBodyTooLarge:
+				reason, status = "body_size", http.StatusRequestEntityTooLarge
+			default:
+				errorHandler(w, http.StatusBadRequest, err)
+				return
+			}
+			config.WebhookAuthFailures.WithLabelValues(reason).Inc()
+			log.Warnf("rejected request path:%s remote:%s reason:%s", r.URL.Path, r.RemoteAddr, reason)
+			errorHandler(w, status, err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authenticate checks r against auth. If a signature is required, the body is read, up to auth.MaxBodyBytes, and
+// replaced by a copy.
+func authenticate(auth *config.WebhookAuth, w http.ResponseWriter, r *http.Request) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !auth.AllowsIP(net.ParseIP(host)) {
+		return errForbiddenIP
+	}
+
+	if auth.BearerToken != "" || auth.BasicAuth != nil {
+		ok := false
+		if authz := r.Header.Get("Authorization"); auth.BearerToken != "" && strings.HasPrefix(authz, "Bearer ") {
+			ok = secureCompare(strings.TrimPrefix(authz, "Bearer "), string(auth.BearerToken))
+		}
+		if user, password, basic := r.BasicAuth(); auth.BasicAuth != nil && basic {
+			// Compare both, so that the time taken does not tell which one is wrong.
+			userOK := secureCompare(user, auth.BasicAuth.Username)
+			ok = secureCompare(password, string(auth.BasicAuth.Password)) && userOK
+		}
+		if !ok {
+			return errUnauthorized
+		}
+	}
+
+	if auth.HMACSecret != "" {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, auth.MaxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return errBodyTooLarge
+			}
+			return fmt.Errorf("failed to read request body: %v", err)
+		}
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(auth.SignatureHeader), "sha256="))
+		if err != nil {
+			return errInvalidSignature
+		}
+		mac := hmac.New(sha256.New, []byte(auth.HMACSecret))
+		_, _ = mac.Write(body)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errInvalidSignature
+		}
+	}
+	return nil
+}
+
+func secureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
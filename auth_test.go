@@ -0,0 +1,116 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticate(t *testing.T) {
+	const credentials = `
+webhook_auth:
+  bearer_token: s3cr3t
+  basic_auth:
+    username: alertmanager
+    password: hunter2
+`
+	const signed = `
+webhook_auth:
+  hmac_secret: k3y
+  signature_header: X-Signature
+`
+	const small = `
+webhook_auth:
+  hmac_secret: k3y
+  max_body_bytes: 16
+`
+	const allowlist = `
+webhook_auth:
+  allowed_cidrs: [192.0.2.0/24, '2001:db8::/32']
+`
+	body := testAlertBody("jira-ab")
+	for _, tcase := range []struct {
+		name    string
+		conf    string
+		remote  string
+		headers map[string]string
+		basic   []string
+		code    int
+		reason  string
+	}{
+		{name: "no webhook_auth", code: http.StatusOK},
+		{name: "missing credentials", conf: credentials, code: http.StatusUnauthorized, reason: "credentials"},
+		{name: "bearer token", conf: credentials, headers: map[string]string{"Authorization": "Bearer s3cr3t"}, code: http.StatusOK},
+		{name: "wrong bearer token", conf: credentials, headers: map[string]string{"Authorization": "Bearer s3cr3"}, code: http.StatusUnauthorized, reason: "credentials"},
+		{name: "basic auth", conf: credentials, basic: []string{"alertmanager", "hunter2"}, code: http.StatusOK},
+		{name: "wrong basic auth user", conf: credentials, basic: []string{"prometheus", "hunter2"}, code: http.StatusUnauthorized, reason: "credentials"},
+		{name: "signature", conf: signed, headers: map[string]string{"X-Signature": sign("k3y", body)}, code: http.StatusOK},
+		{name: "unprefixed signature", conf: signed, headers: map[string]string{"X-Signature": strings.TrimPrefix(sign("k3y", body), "sha256=")}, code: http.StatusOK},
+		{name: "missing signature", conf: signed, code: http.StatusUnauthorized, reason: "signature"},
+		{name: "wrong signature", conf: signed, headers: map[string]string{"X-Signature": sign("key", body)}, code: http.StatusUnauthorized, reason: "signature"},
+		{name: "body too large", conf: small, headers: map[string]string{"X-Jiralert-Signature": sign("k3y", body)}, code: http.StatusRequestEntityTooLarge, reason: "body_size"},
+		{name: "allowed address", conf: allowlist, remote: "192.0.2.7:4711", code: http.StatusOK},
+		{name: "allowed IPv6 address", conf: allowlist, remote: "[2001:db8::1]:4711", code: http.StatusOK},
+		{name: "forbidden address", conf: allowlist, remote: "198.51.100.7:4711", code: http.StatusForbidden, reason: "ip"},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			jira := newTestJira(t)
+			reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config(tcase.conf)))
+			require.NoError(t, err)
+			h := Authenticate(reloader, func(w http.ResponseWriter, r *http.Request) {
+				// The handler still gets the whole body after the signature was checked.
+				b, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, body, string(b))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(body))
+			if tcase.remote != "" {
+				req.RemoteAddr = tcase.remote
+			}
+			for k, v := range tcase.headers {
+				req.Header.Set(k, v)
+			}
+			if tcase.basic != nil {
+				req.SetBasicAuth(tcase.basic[0], tcase.basic[1])
+			}
+			var before float64
+			if tcase.reason != "" {
+				before = testutil.ToFloat64(config.WebhookAuthFailures.WithLabelValues(tcase.reason))
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			require.Equal(t, tcase.code, rec.Code, rec.Body.String())
+			if tcase.reason != "" {
+				require.Equal(t, before+1, testutil.ToFloat64(config.WebhookAuthFailures.WithLabelValues(tcase.reason)))
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultConfigFile = "./jiralert.yml"
+	defaultListenPort = "8080"
+
+	defaultShutdownTimeout = 30 * time.Second
+
+	// envPrefix is prepended to the upper-cased flag name (with '.' and '-' replaced by '_') to get the
+	// environment variable that provides the flag's default value, e.g. JIRALERT_LOG_LEVEL for -log.level.
+	envPrefix = "JIRALERT_"
+)
+
+// parseFlags builds the Flg struct from the command line arguments, falling back to JIRALERT_* environment variables
+// and then to the built-in defaults. Command line flags always take precedence over the environment.
+func parseFlags(name string, args []string, getenv func(string) string) (*Flg, error) {
+	fg := &Flg{Version: "<local build>"}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&fg.Config, "config", envOrDefault(getenv, "config", defaultConfigFile),
+		"The JIRAlert configuration file. ($JIRALERT_CONFIG)")
+	fs.StringVar(&fg.ListenAddr, "listen-address", envOrDefault(getenv, "listen-address", defaultListenAddr(getenv)),
+		"The address to listen on for HTTP requests. ($JIRALERT_LISTEN_ADDRESS, or :$PORT)")
+	fs.StringVar(&fg.Loglevel, "log.level", envOrDefault(getenv, "log.level", "info"),
+		"Log filtering level (debug, info, warn, error). ($JIRALERT_LOG_LEVEL)")
+	fs.StringVar(&fg.Logfmt, "log.format", envOrDefault(getenv, "log.format", logFormatLogfmt),
+		"Log format to use ("+logFormatLogfmt+", "+logFormatJSON+"). ($JIRALERT_LOG_FORMAT)")
+
+	hashJiraLabel, err := envBool(getenv, "hash-jira-label")
+	if err != nil {
+		return nil, err
+	}
+	fs.BoolVar(&fg.HashJiraLabel, "hash-jira-label", hashJiraLabel,
+		"If enabled, the group labels are hashed into the Jira label, see #79. ($JIRALERT_HASH_JIRA_LABEL)")
+
+	shutdownTimeout, err := envDuration(getenv, "shutdown-timeout", defaultShutdownTimeout)
+	if err != nil {
+		return nil, err
+	}
+	fs.DurationVar(&fg.ShutdownTimeout, "shutdown-timeout", shutdownTimeout,
+		"How long to wait for in-flight notifications to finish on SIGTERM. ($JIRALERT_SHUTDOWN_TIMEOUT)")
+
+	if fg.Async, err = envBool(getenv, "async"); err != nil {
+		return nil, err
+	}
+	fs.BoolVar(&fg.Async, "async", fg.Async,
+		"If enabled, webhooks are queued and answered with 202, and a worker pool notifies Jira. ($JIRALERT_ASYNC)")
+	if err := queueFlags(fs, getenv, &fg.Queue); err != nil {
+		return nil, err
+	}
+
+	fs.StringVar(&fg.JournalDir, "journal.dir", envOrDefault(getenv, "journal.dir", ""),
+		"Directory of the write-ahead journal that keeps accepted notifications until Jira is updated, so that they are "+
+			"replayed after a restart. Disabled if empty. ($JIRALERT_JOURNAL_DIR)")
+	fs.StringVar(&fg.WebConfigFile, "web.config.file", envOrDefault(getenv, "web.config.file", ""),
+		"Web configuration file with the tls_server_config to serve HTTPS with, optionally requiring client "+
+			"certificates. Plain HTTP if empty. ($JIRALERT_WEB_CONFIG_FILE)")
+	fs.StringVar(&fg.LockDir, "lock.dir", envOrDefault(getenv, "lock.dir", ""),
+		"Directory of the lock files that serialize the handling of each alert group with other JIRAlert processes "+
+			"on the host. Only this process is serialized if empty. ($JIRALERT_LOCK_DIR)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := fg.validate(); err != nil {
+		return nil, err
+	}
+	return fg, nil
+}
+
+// validate checks every flag value, so that misconfiguration is reported at startup instead of on first use.
+func (fg *Flg) validate() error {
+	if fg.Config == "" {
+		return fmt.Errorf("-config must not be empty")
+	}
+	if _, err := parseLogLevel(fg.Loglevel); err != nil {
+		return err
+	}
+	switch fg.Logfmt {
+	case logFormatLogfmt, logFormatJSON:
+	default:
+		return fmt.Errorf("invalid -log.format %q, must be one of %s, %s", fg.Logfmt, logFormatLogfmt, logFormatJSON)
+	}
+	_, port, err := net.SplitHostPort(fg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("invalid -listen-address %q: %v", fg.ListenAddr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port %q in -listen-address %q", port, fg.ListenAddr)
+	}
+	if fg.ShutdownTimeout <= 0 {
+		return fmt.Errorf("-shutdown-timeout must be positive, got %s", fg.ShutdownTimeout)
+	}
+	if fg.Async {
+		if err := fg.Queue.Validate(); err != nil {
+			return errors.Wrap(err, "invalid -async.* flags")
+		}
+	}
+	return nil
+}
+
+// queueFlags registers the -async.* flags that configure the notification queue.
+func queueFlags(fs *flag.FlagSet, getenv func(string) string, opts *jiralert.QueueOptions) error {
+	capacity, err := envInt(getenv, "async.queue-capacity", 1000)
+	if err != nil {
+		return err
+	}
+	fs.IntVar(&opts.Capacity, "async.queue-capacity", capacity,
+		"Maximum number of notifications waiting in the queue. ($JIRALERT_ASYNC_QUEUE_CAPACITY)")
+	workers, err := envInt(getenv, "async.workers", 4)
+	if err != nil {
+		return err
+	}
+	fs.IntVar(&opts.Workers, "async.workers", workers,
+		"Number of workers notifying Jira concurrently. ($JIRALERT_ASYNC_WORKERS)")
+	opts.FullPolicy = jiralert.FullPolicy(envOrDefault(getenv, "async.queue-full-policy", string(jiralert.FullPolicyReject)))
+	fs.Var((*fullPolicyValue)(&opts.FullPolicy), "async.queue-full-policy",
+		"What to do with a new notification when the queue is full: "+string(jiralert.FullPolicyReject)+" (answer 503) or "+
+			string(jiralert.FullPolicyDropOldest)+". ($JIRALERT_ASYNC_QUEUE_FULL_POLICY)")
+	attempts, err := envInt(getenv, "async.max-attempts", 5)
+	if err != nil {
+		return err
+	}
+	fs.IntVar(&opts.MaxAttempts, "async.max-attempts", attempts,
+		"Maximum attempts per notification when Jira fails with a retryable error. ($JIRALERT_ASYNC_MAX_ATTEMPTS)")
+	for _, d := range []struct {
+		name  string
+		def   time.Duration
+		dst   *time.Duration
+		usage string
+	}{
+		{"async.initial-backoff", time.Second, &opts.InitialBackoff, "Backoff before the first retry, doubled on every further retry."},
+		{"async.max-backoff", time.Minute, &opts.MaxBackoff, "Upper bound of the backoff between retries."},
+		{"async.notify-timeout", 30 * time.Second, &opts.NotifyTimeout, "Timeout of a single notification attempt."},
+	} {
+		v, err := envDuration(getenv, d.name, d.def)
+		if err != nil {
+			return err
+		}
+		fs.DurationVar(d.dst, d.name, v, d.usage+" ($"+envName(d.name)+")")
+	}
+	return nil
+}
+
+type fullPolicyValue jiralert.FullPolicy
+
+func (v *fullPolicyValue) String() string { return string(*v) }
+
+func (v *fullPolicyValue) Set(s string) error {
+	*v = fullPolicyValue(s)
+	return nil
+}
+
+// setupLogger configures the standard logrus logger according to -log.level and -log.format.
+func (fg *Flg) setupLogger() {
+	lvl, _ := parseLogLevel(fg.Loglevel)
+	log.SetLevel(lvl)
+	if fg.Logfmt == logFormatJSON {
+		log.SetFormatter(&log.JSONFormatter{})
+		return
+	}
+	log.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
+}
+
+func parseLogLevel(level string) (log.Level, error) {
+	switch level {
+	case "debug":
+		return log.DebugLevel, nil
+	case "info":
+		return log.InfoLevel, nil
+	case "warn":
+		return log.WarnLevel, nil
+	case "error":
+		return log.ErrorLevel, nil
+	}
+	return log.InfoLevel, fmt.Errorf("invalid -log.level %q, must be one of debug, info, warn, error", level)
+}
+
+// defaultListenAddr honors the PORT variable set by most PaaS runtimes when no explicit address is configured.
+func defaultListenAddr(getenv func(string) string) string {
+	if port := getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":" + defaultListenPort
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(flagName))
+}
+
+func envOrDefault(getenv func(string) string, flagName, def string) string {
+	if v := getenv(envName(flagName)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(getenv func(string) string, flagName string) (bool, error) {
+	v := getenv(envName(flagName))
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for $%s: %v", v, envName(flagName), err)
+	}
+	return b, nil
+}
+
+func envInt(getenv func(string) string, flagName string, def int) (int, error) {
+	v := getenv(envName(flagName))
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for $%s: %v", v, envName(flagName), err)
+	}
+	return i, nil
+}
+
+func envDuration(getenv func(string) string, flagName string, def time.Duration) (time.Duration, error) {
+	v := getenv(envName(flagName))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for $%s: %v", v, envName(flagName), err)
+	}
+	return d, nil
+}
+
+// mustParseFlags parses os.Args and the process environment, exiting on error.
+func mustParseFlags() *Flg {
+	fg, err := parseFlags(os.Args[0], os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return fg
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(k string) string { return env[k] }
+}
+
+func defaultQueueOptions() jiralert.QueueOptions {
+	return jiralert.QueueOptions{
+		Capacity:       1000,
+		Workers:        4,
+		FullPolicy:     jiralert.FullPolicyReject,
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		NotifyTimeout:  30 * time.Second,
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected *Flg
+		err      string
+	}{
+		{
+			name: "defaults",
+			expected: &Flg{
+				Config: defaultConfigFile, ListenAddr: ":8080", Loglevel: "info", Logfmt: logFormatLogfmt,
+			},
+		},
+		{
+			name: "environment",
+			env: map[string]string{
+				"JIRALERT_CONFIG":          "/config/jiralert.yml",
+				"JIRALERT_LISTEN_ADDRESS":  "127.0.0.1:9097",
+				"JIRALERT_LOG_LEVEL":       "debug",
+				"JIRALERT_LOG_FORMAT":      "json",
+				"JIRALERT_HASH_JIRA_LABEL": "true",
+			},
+			expected: &Flg{
+				Config: "/config/jiralert.yml", ListenAddr: "127.0.0.1:9097", Loglevel: "debug", Logfmt: logFormatJSON, HashJiraLabel: true,
+			},
+		},
+		{
+			name: "flags override environment",
+			args: []string{"-config=a.yml", "-listen-address=:9097", "-log.level=warn", "-hash-jira-label=false"},
+			env: map[string]string{
+				"JIRALERT_CONFIG":          "b.yml",
+				"JIRALERT_LOG_LEVEL":       "debug",
+				"JIRALERT_HASH_JIRA_LABEL": "true",
+			},
+			expected: &Flg{
+				Config: "a.yml", ListenAddr: ":9097", Loglevel: "warn", Logfmt: logFormatLogfmt,
+			},
+		},
+		{
+			name: "PORT is honored",
+			env:  map[string]string{"PORT": "9999"},
+			expected: &Flg{
+				Config: defaultConfigFile, ListenAddr: ":9999", Loglevel: "info", Logfmt: logFormatLogfmt,
+			},
+		},
+		{
+			name: "listen address wins over PORT",
+			env:  map[string]string{"PORT": "9999", "JIRALERT_LISTEN_ADDRESS": ":9097"},
+			expected: &Flg{
+				Config: defaultConfigFile, ListenAddr: ":9097", Loglevel: "info", Logfmt: logFormatLogfmt,
+			},
+		},
+		{
+			name: "shutdown timeout",
+			args: []string{"-shutdown-timeout=2m"},
+			expected: &Flg{
+				Config: defaultConfigFile, ListenAddr: ":8080", Loglevel: "info", Logfmt: logFormatLogfmt, ShutdownTimeout: 2 * time.Minute,
+			},
+		},
+		{
+			name: "async",
+			args: []string{"-async", "-async.workers=8", "-async.queue-full-policy=drop-oldest", "-async.max-backoff=10s"},
+			env:  map[string]string{"JIRALERT_ASYNC_QUEUE_CAPACITY": "50"},
+			expected: &Flg{
+				Config: defaultConfigFile, ListenAddr: ":8080", Loglevel: "info", Logfmt: logFormatLogfmt, Async: true,
+				Queue: func() jiralert.QueueOptions {
+					o := defaultQueueOptions()
+					o.Capacity, o.Workers, o.FullPolicy, o.MaxBackoff = 50, 8, jiralert.FullPolicyDropOldest, 10*time.Second
+					return o
+				}(),
+			},
+		},
+		{
+			name:     "journal",
+			env:      map[string]string{"JIRALERT_JOURNAL_DIR": "/var/lib/jiralert"},
+			expected: &Flg{Config: defaultConfigFile, ListenAddr: ":8080", Loglevel: "info", Logfmt: logFormatLogfmt, JournalDir: "/var/lib/jiralert"},
+		},
+		{
+			name:     "web config",
+			args:     []string{"-web.config.file=web.yml"},
+			expected: &Flg{Config: defaultConfigFile, ListenAddr: ":8080", Loglevel: "info", Logfmt: logFormatLogfmt, WebConfigFile: "web.yml"},
+		},
+		{
+			name:     "lock dir",
+			env:      map[string]string{"JIRALERT_LOCK_DIR": "/run/jiralert/locks"},
+			expected: &Flg{Config: defaultConfigFile, ListenAddr: ":8080", Loglevel: "info", Logfmt: logFormatLogfmt, LockDir: "/run/jiralert/locks"},
+		},
+		{name: "bad async policy", args: []string{"-async", "-async.queue-full-policy=block"}, err: `invalid queue full policy "block"`},
+		{name: "bad async backoff", args: []string{"-async", "-async.initial-backoff=2m"}, err: "queue backoff must satisfy"},
+		{name: "bad async env", env: map[string]string{"JIRALERT_ASYNC_WORKERS": "many"}, err: "$JIRALERT_ASYNC_WORKERS"},
+		{name: "bad shutdown timeout", args: []string{"-shutdown-timeout=0s"}, err: "-shutdown-timeout must be positive"},
+		{name: "bad log level", args: []string{"-log.level=trace"}, err: `invalid -log.level "trace"`},
+		{name: "bad log format", args: []string{"-log.format=xml"}, err: `invalid -log.format "xml"`},
+		{name: "bad listen address", args: []string{"-listen-address=8080"}, err: `invalid -listen-address "8080"`},
+		{name: "bad PORT", env: map[string]string{"PORT": "http"}, err: `invalid port "http"`},
+		{name: "empty config", args: []string{"-config="}, err: "-config must not be empty"},
+		{name: "bad bool env", env: map[string]string{"JIRALERT_HASH_JIRA_LABEL": "yes please"}, err: "$JIRALERT_HASH_JIRA_LABEL"},
+		{name: "positional args", args: []string{"jiralert.yml"}, err: "unexpected arguments: jiralert.yml"},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fg, err := parseFlags("jiralert", tcase.args, testEnv(tcase.env))
+			if tcase.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tcase.err)
+				return
+			}
+			require.NoError(t, err)
+			tcase.expected.Version = "<local build>"
+			if tcase.expected.ShutdownTimeout == 0 {
+				tcase.expected.ShutdownTimeout = defaultShutdownTimeout
+			}
+			if tcase.expected.Queue == (jiralert.QueueOptions{}) {
+				tcase.expected.Queue = defaultQueueOptions()
+			}
+			require.Equal(t, tcase.expected, fg)
+		})
+	}
+}
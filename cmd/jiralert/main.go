@@ -15,24 +15,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"github.com/Hoverhuang-er/go-actuator"
 	"github.com/Hoverhuang-er/jiralert"
-	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
-	"github.com/Hoverhuang-er/jiralert/pkg/config"
 	"github.com/Hoverhuang-er/jiralert/pkg/notify"
-	"github.com/Hoverhuang-er/jiralert/pkg/template"
-	"github.com/andygrunwald/go-jira"
-	jsoniter "github.com/json-iterator/go"
+	"github.com/Hoverhuang-er/jiralert/pkg/web"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"gocloud.dev/server"
+	"gocloud.dev/server/health"
 	"gocloud.dev/server/requestlog"
-	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 )
 
@@ -49,151 +47,160 @@ type Flg struct {
 	ListenAddr    string
 	HashJiraLabel bool
 	Version       string
+
+	// ShutdownTimeout bounds how long in-flight notifications are waited for on SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// Async enables the notification queue; Queue configures it.
+	Async bool
+	Queue jiralert.QueueOptions
+
+	// JournalDir is where accepted notifications are journaled until delivered; empty disables the journal.
+	JournalDir string
+
+	// WebConfigFile configures TLS termination; empty serves plain HTTP.
+	WebConfigFile string
+
+	// LockDir holds the lock files serializing alert groups across processes; empty locks within the process only.
+	LockDir string
 }
 
 func main() {
 	ncu := runtime.NumCPU()
 	runtime.GOMAXPROCS(ncu)
-	fg := Flg{
-		Loglevel:      "info",
-		Logfmt:        logFormatLogfmt,
-		Config:        "./jiralert.yml",
-		ListenAddr:    ":8080",
-		HashJiraLabel: false,
-		Version:       "<local build>",
-	}
+	fg := mustParseFlags()
+	fg.setupLogger()
+	log.Infof("starting jiralert version:%s", fg.Version)
 	if !fg.HashJiraLabel {
-		log.Warn("msg", "Using deprecated jira label generation - "+
-			"please read https://github.com/prometheus-community/jiralert/pull/79 "+
+		log.Warn("Using deprecated jira label generation - " +
+			"please read https://github.com/prometheus-community/jiralert/pull/79 " +
 			"and try -hash-jira-label")
 	}
-	config2, _, err := config.LoadFile(fg.Config)
+	reloader, err := jiralert.NewReloader(fg.Config)
 	if err != nil {
-		log.Errorf("msg", "loading configuration path%s err%v", fg.Config, err)
+		log.Errorf("loading configuration path:%s err:%v", fg.Config, err)
 		os.Exit(1)
 	}
-
-	tmpl, err := template.LoadTemplate(config2.Template)
-	if err != nil {
-		log.Error("msg", "loading templates", "path", config2.Template, "err", err)
-		return
-	}
-	srv := server.New(http.DefaultServeMux, &server.Options{
-		RequestLogger: requestlog.NewNCSALogger(os.Stdout, func(error) {}),
-	})
-	http.HandleFunc("/alert/deprecated", func(w http.ResponseWriter, req *http.Request) {
-		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
-		defer func() {
-			_ = req.Body.Close()
-			cancel()
-		}()
-		// https://godoc.org/github.com/prometheus/alertmanager/template#Data
-		data := alertmanager.Data{}
-		body, err := io.ReadAll(req.Body)
-		if err != nil {
-			errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to read request body: %v", err))
-			return
-		}
-		if err := json.Unmarshal(body, &data); err != nil {
-			errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to parse request body: %v", err))
-			return
-		}
-		conf := config2.ReceiverByName(ctx, data.Receiver)
-		if conf == nil {
-			log.Errorf("config not found", conf)
-			errorHandler(w, http.StatusOK, fmt.Errorf("receiver missing: %s", data.Receiver))
-			return
-		}
-		// TODO: Consider reusing notifiers or just jira clients to reuse connections.
-		tp := jira.BasicAuthTransport{
-			Username: conf.User,
-			Password: string(conf.Password),
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("received SIGHUP, reloading configuration")
+			_ = reloader.Reload()
 		}
-		client, err := jira.NewClient(tp.Client(), conf.APIURL)
+	}()
+	driver := server.NewDefaultDriver()
+	if fg.WebConfigFile != "" {
+		driver.Server.TLSConfig, err = loadWebConfig(fg.WebConfigFile)
 		if err != nil {
-			log.Errorf("Failed to create Jira client: %v", err)
-			errorHandler(w, http.StatusOK, err)
-			return
+			log.Errorf("loading web configuration path:%s err:%v", fg.WebConfigFile, err)
+			os.Exit(1)
 		}
-		var status int
-		key, retry, err := notify.NewReceiver(conf, tmpl, client.Issue).Notify(ctx, &data, fg.HashJiraLabel)
+	}
+	var locker notify.Locker = notify.NewKeyedMutex()
+	if fg.LockDir != "" {
+		locker, err = notify.NewFileLocker(fg.LockDir)
 		if err != nil {
-			if retry {
-				status = http.StatusServiceUnavailable
-			}
-			log.Errorf("send notify error:%s", err.Error())
-			errorHandler(w, status, err)
-			return
+			log.Errorf("failed to set up alert group locks dir:%s err:%v", fg.LockDir, err)
+			os.Exit(1)
 		}
-		wb, _ := jsoniter.MarshalToString(map[string]interface{}{
-			"code":      http.StatusOK,
-			"msg":       "success",
-			"issue_key": key,
-		})
-		w.Write([]byte(wb))
-		w.WriteHeader(http.StatusOK)
-		config.RequestTotal.WithLabelValues(conf.Name, "200").Inc()
-		return
+	}
+	drainer := &jiralert.Drainer{}
+	srv := server.New(http.DefaultServeMux, &server.Options{
+		RequestLogger: requestlog.NewNCSALogger(os.Stdout, func(error) {}),
+		HealthChecks:  []health.Checker{drainer},
+		Driver:        driver,
 	})
-	http.HandleFunc("/alert", func(writer http.ResponseWriter, request *http.Request) {
-		var data alertmanager.Data
-		ctx, cancel := context.WithTimeout(request.Context(), 30*time.Second)
-		defer func() {
-			_ = request.Body.Close()
-			cancel()
-		}()
-		if err := jsoniter.NewDecoder(request.Body).Decode(&data); err != nil {
-			log.Errorf("msg", "failed to parse request body: %v", err)
-			return
-		}
-		je := jiralert.Jiralert{
-			Input:       &data,
-			Config:      config2,
-			Template:    tmpl,
-			IsHashLable: false,
+	var queue *jiralert.Queue
+	if fg.Async {
+		queue, err = jiralert.NewQueue(fg.Queue)
+		if err != nil {
+			log.Errorf("failed to create notification queue: %v", err)
+			os.Exit(1)
 		}
-		resp, err := je.NewIssues(ctx)
+		log.Infof("asynchronous mode enabled capacity:%d workers:%d", fg.Queue.Capacity, fg.Queue.Workers)
+	}
+	var journal *jiralert.Journal
+	if fg.JournalDir != "" {
+		journal, err = jiralert.OpenJournal(fg.JournalDir)
 		if err != nil {
-			log.Errorf("msg", "failed to create jira issue: %v", err)
-			return
+			log.Errorf("failed to open notification journal dir:%s err:%v", fg.JournalDir, err)
+			os.Exit(1)
 		}
-		wb, _ := jsoniter.Marshal(resp)
-		writer.Write(wb)
-		writer.WriteHeader(http.StatusOK)
-		return
-	})
+	}
+	webhook := jiralert.NewWebhook(reloader, fg.HashJiraLabel, queue, journal, locker)
+	replayCtx, stopReplay := context.WithCancel(context.Background())
+	defer stopReplay()
+	if drainer.Acquire() {
+		go func() {
+			defer drainer.Release()
+			webhook.Replay(replayCtx)
+		}()
+	}
+	http.HandleFunc("/alert/deprecated", drainer.Wrap(jiralert.Authenticate(reloader, webhook.DeprecatedAlertHandlerFunc())))
+	http.HandleFunc("/alert", drainer.Wrap(jiralert.Authenticate(reloader, webhook.AlertHandlerFunc())))
+	http.HandleFunc("/api/v1/issues/", drainer.Wrap(jiralert.Authenticate(reloader, jiralert.IssuesHandlerFunc(reloader, locker))))
+	http.HandleFunc("/api/v1/preview", jiralert.Authenticate(reloader, webhook.PreviewHandlerFunc()))
 	http.HandleFunc("/", jiralert.HomeHandlerFunc())
-	http.HandleFunc("/config", jiralert.ConfigHandlerFunc(config2))
-	http.HandleFunc("/healthz", Healthcheck)
+	http.HandleFunc("/config", jiralert.ConfigHandlerFunc(reloader.Config))
+	http.HandleFunc("/-/reload", jiralert.Authenticate(reloader, jiralert.ReloadHandlerFunc(reloader)))
+	http.HandleFunc("/healthz", jiralert.HealthzHandlerFunc())
 	http.HandleFunc("/actuator/*endpoint", Healthcheck)
 	http.Handle("/metrics", promhttp.Handler())
-	if os.Getenv("PORT") != "" {
-		fg.ListenAddr = ":8080"
+	srvErr := make(chan error, 1)
+	go func() {
+		if driver.Server.TLSConfig != nil {
+			log.Infof("listening address:%s tls:true", fg.ListenAddr)
+			// The certificate comes from the TLS configuration, which reloads it when it changes.
+			srvErr <- srv.ListenAndServeTLS(fg.ListenAddr, "", "")
+			return
+		}
+		log.Infof("listening address:%s", fg.ListenAddr)
+		srvErr <- srv.ListenAndServe(fg.ListenAddr)
+	}()
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-srvErr:
+		log.Fatalf("server exited abnormally: %v", err)
+	case sig := <-term:
+		log.Infof("received %s, draining in-flight notifications timeout:%s", sig, fg.ShutdownTimeout)
 	}
-	log.Warn("listening", "address", fg.ListenAddr)
-	if srv.ListenAndServe(fg.ListenAddr) != http.ErrServerClosed {
-		log.Fatal("msg", "server exited abnormally")
-		os.Exit(1)
+	ctx, cancel := context.WithTimeout(context.Background(), fg.ShutdownTimeout)
+	defer cancel()
+	stopReplay()
+	shutdown(ctx, srv, drainer, queue, journal)
+}
+
+// loadWebConfig returns the server TLS configuration of the web configuration file.
+func loadWebConfig(filename string) (*tls.Config, error) {
+	cfg, err := web.LoadFile(filename)
+	if err != nil {
+		return nil, err
 	}
+	return web.NewTLSConfig(cfg.TLSServerConfig)
 }
 
-func errorHandler(w http.ResponseWriter, status int, err error) {
-	w.WriteHeader(status)
-	response := struct {
-		Error   bool
-		Status  int
-		Message string
-	}{
-		true,
-		status,
-		err.Error(),
+// shutdown fails readiness and rejects new webhooks, waits for in-flight and queued notifications and then closes the
+// listener and the journal, all bounded by ctx.
+func shutdown(ctx context.Context, srv *server.Server, drainer *jiralert.Drainer, queue *jiralert.Queue, journal *jiralert.Journal) {
+	if err := drainer.Drain(ctx); err != nil {
+		log.Errorf("in-flight notifications did not finish before the shutdown timeout: %v", err)
+	}
+	if queue != nil {
+		if err := queue.Close(ctx); err != nil {
+			log.Errorf("queued notifications did not finish before the shutdown timeout: %v", err)
+		}
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shut down HTTP server: %v", err)
+	}
+	if journal != nil {
+		if err := journal.Close(); err != nil {
+			log.Errorf("failed to close notification journal: %v", err)
+		}
 	}
-	// JSON response
-	bytes, _ := json.Marshal(response)
-	json := string(bytes[:])
-	fmt.Fprint(w, json)
-	log.Error("msg", "error handling request", "statusCode", status, "statusText", http.StatusText(status),
-		"err", err)
+	log.Info("shutdown complete")
 }
 
 func Healthcheck(w http.ResponseWriter, r *http.Request) {
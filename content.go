@@ -19,6 +19,8 @@ import (
 	"net/http"
 
 	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	jsoniter "github.com/json-iterator/go"
 )
 
 const (
@@ -113,8 +115,31 @@ func HomeHandlerFunc() func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-// ConfigHandlerFunc is the HTTP handler for the `/config` page. It outputs the configuration marshaled in YAML format.
-func ConfigHandlerFunc(config *config.Config) func(http.ResponseWriter, *http.Request) {
+// HealthzHandlerFunc is the HTTP handler for `/healthz`. It reports the state of the circuit breaker of every Jira
+// instance talked to since startup, and a status of "degraded" if any of them is not closed. It always answers 200, so
+// that an unavailable Jira does not get JIRAlert restarted.
+func HealthzHandlerFunc() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("only GET allowed"))
+			return
+		}
+		status, circuits := "ok", notify.CircuitStates()
+		for _, state := range circuits {
+			if state != "closed" {
+				status = "degraded"
+			}
+		}
+		b, _ := jsoniter.Marshal(map[string]interface{}{"status": status, "jira_circuits": circuits})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}
+}
+
+// ConfigHandlerFunc is the HTTP handler for the `/config` page. It outputs the currently active configuration
+// marshaled in YAML format.
+func ConfigHandlerFunc(currentConfig func() *config.Config) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -124,7 +149,7 @@ func ConfigHandlerFunc(config *config.Config) func(http.ResponseWriter, *http.Re
 
 		if err := configTemplate.Execute(w, &tdata{
 			DocsURL: docsURL,
-			Config:  config.String(),
+			Config:  currentConfig().String(),
 		}); err != nil {
 			w.WriteHeader(500)
 		}
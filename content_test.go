@@ -0,0 +1,64 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthzHandlerFunc(t *testing.T) {
+	defer func(retry notify.RetryPolicy) { notify.DefaultRetryPolicy = retry }(notify.DefaultRetryPolicy)
+	notify.DefaultRetryPolicy = notify.RetryPolicy{MaxAttempts: 1}
+
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config("    circuit_breaker: {failure_threshold: 1, open_duration: 1h}\n")))
+	require.NoError(t, err)
+	h := NewWebhook(reloader, true, nil, nil, nil).DeprecatedAlertHandlerFunc()
+	healthz := func() (status string, circuits map[string]string) {
+		rec := httptest.NewRecorder()
+		HealthzHandlerFunc()(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Status       string            `json:"status"`
+			JiraCircuits map[string]string `json:"jira_circuits"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Status, body.JiraCircuits
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alert/deprecated", strings.NewReader(testAlertBody("jira-ab"))))
+	require.Equal(t, http.StatusOK, rec.Code)
+	status, circuits := healthz()
+	require.Equal(t, "ok", status)
+	require.Equal(t, "closed", circuits[jira.URL])
+
+	// Once Jira failed, the circuit is open and webhooks are answered with 503 without waiting for Jira.
+	jira.failWith.Store(http.StatusBadGateway)
+	for _, expected := range []string{"returned status 502", "circuit breaker open"} {
+		rec = httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/alert/deprecated", strings.NewReader(testAlertBody("jira-ab"))))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		require.Contains(t, rec.Body.String(), expected)
+	}
+	status, circuits = healthz()
+	require.Equal(t, "degraded", status)
+	require.Equal(t, "open", circuits[jira.URL])
+}
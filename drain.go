@@ -0,0 +1,93 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errDraining is returned by the readiness check once shutdown has started.
+var errDraining = errors.New("jiralert is shutting down")
+
+// Drainer tracks in-flight notifications, so that shutdown can stop accepting new webhooks and wait for the
+// search/create/transition sequence of the ones already started to complete.
+type Drainer struct {
+	mtx      sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// Acquire registers a new in-flight notification. It returns false once draining has started, in which case the
+// caller must not start any Jira work and must not call Release.
+func (d *Drainer) Acquire() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight.Add(1)
+	return true
+}
+
+// Release marks an in-flight notification registered with Acquire as done.
+func (d *Drainer) Release() {
+	d.inFlight.Done()
+}
+
+// Drain stops accepting new notifications and waits for the in-flight ones to finish, or for ctx to be done,
+// whichever comes first.
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mtx.Lock()
+	d.draining = true
+	d.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CheckHealth implements gocloud's health.Checker, failing readiness while draining so that the instance is taken
+// out of load balancing before it stops listening.
+func (d *Drainer) CheckHealth() error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.draining {
+		return errDraining
+	}
+	return nil
+}
+
+// Wrap rejects requests with 503 once draining has started and tracks the others as in-flight notifications.
+// Alertmanager retries on 5xx, so rejected webhooks are delivered to another replica or after the restart.
+func (d *Drainer) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.Acquire() {
+			http.Error(w, errDraining.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer d.Release()
+		h(w, r)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainer(t *testing.T) {
+	d := &Drainer{}
+	require.NoError(t, d.CheckHealth())
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	h := d.Wrap(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusOK)
+	})
+
+	inFlight := httptest.NewRecorder()
+	go h(inFlight, httptest.NewRequest(http.MethodPost, "/alert", nil))
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- d.Drain(context.Background()) }()
+
+	// Readiness fails and new webhooks are rejected while the in-flight one is still running.
+	require.Eventually(t, func() bool { return d.CheckHealth() != nil }, time.Second, time.Millisecond)
+	rejected := httptest.NewRecorder()
+	h(rejected, httptest.NewRequest(http.MethodPost, "/alert", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned before the in-flight notification finished")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(finish)
+	require.NoError(t, <-drained)
+	require.Equal(t, http.StatusOK, inFlight.Code)
+}
+
+func TestDrainer_Timeout(t *testing.T) {
+	d := &Drainer{}
+	require.True(t, d.Acquire())
+	defer d.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, d.Drain(ctx))
+	require.False(t, d.Acquire())
+}
@@ -12,6 +12,7 @@ require (
 	github.com/stretchr/testify v1.8.1
 	github.com/trivago/tgo v1.0.7
 	gocloud.dev v0.27.0
+	golang.org/x/net v0.0.0-20220802222814-0bcc04d9c69b
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -37,6 +38,7 @@ require (
 	github.com/yusufpapurcu/wmi v1.2.2 // indirect
 	go.opencensus.io v0.23.0 // indirect
 	golang.org/x/sys v0.1.0 // indirect
+	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
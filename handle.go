@@ -2,12 +2,11 @@ package jiralert
 
 import (
 	"context"
-	"crypto/tls"
 	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
 	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/jiraclient"
 	"github.com/Hoverhuang-er/jiralert/pkg/notify"
 	"github.com/Hoverhuang-er/jiralert/pkg/template"
-	"github.com/andygrunwald/go-jira"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,11 +28,50 @@ Source: {{ .GeneratorURL }}
 {{ end }}{{ end }}
 `
 
+// ErrRetry is returned by NewIssues, UpdateIssues and CloseIssues when Jira failed in a way that is worth retrying.
+// Match it with errors.Is; the error returned also unwraps to the failure.
+var ErrRetry = errors.New("retry")
+
+// retryError is a failure worth retrying: errors.Is matches it with ErrRetry as well as with the causes of err.
+type retryError struct {
+	err error
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error() + ": " + ErrRetry.Error()
+}
+
+// Is reports whether target is ErrRetry.
+func (e *retryError) Is(target error) bool {
+	return target == ErrRetry
+}
+
+// Cause returns the failure.
+func (e *retryError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the failure.
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// ErrNoCloseState is returned by CloseIssues when neither a state nor the receiver's auto_resolve state is given.
+var ErrNoCloseState = errors.New("no state to close the issue in, set auto_resolve or pass a state")
+
 type Jiralert struct {
 	Input       *alertmanager.Data
 	Config      *config.Config
 	Template    *template.Template
+	Clients     *jiraclient.Registry
 	IsHashLable bool
+
+	// Group is the Jira label of the alert group whose issue UpdateIssues and CloseIssues operate on.
+	Group string
+	// State is the state CloseIssues transitions the issue into; it defaults to the receiver's auto_resolve state.
+	State string
+	// Locker serializes the handling of each alert group with the other users of it. If nil, it is not serialized.
+	Locker notify.Locker
 }
 type JiralertFunc interface {
 	NewIssues(ctx context.Context) (string, error)
@@ -41,43 +79,129 @@ type JiralertFunc interface {
 	CloseIssues(ctx context.Context) (string, error)
 }
 
-// New Issues a new Jiralert.
+// New Issues a new Jiralert. If it fails, the body returned with the error is the *notify.NotifyResult of what was done
+// before the failure, or empty if the receiver was not notified.
 func (je Jiralert) NewIssues(ctx context.Context) (string, error) {
 	conf := CheckConfig(ctx, je.Config)
 	if err := checkTemplate(ctx); err != nil {
 		config.RequestError.WithLabelValues("template", "500").Inc()
 		return "", errors.Wrap(err, "failed to check template")
 	}
-	conf2 := conf.ReceiverByName(ctx, conf.Receivers[0].Name)
-	tp := jira.BasicAuthTransport{
-		Username: conf2.User,
-		Password: string(conf2.Password),
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-	}
-	client, err := jira.NewClient(tp.Client(), conf2.APIURL)
+	_, receiver, err := je.receiver(ctx, conf)
 	if err != nil {
-		config.RequestError.WithLabelValues("newclient", "500").Inc()
 		return "", err
 	}
-	key, retry, err := notify.NewReceiver(conf2, je.Template, client.Issue).Notify(ctx, je.Input, je.IsHashLable)
+	res, err := receiver.Notify(ctx, je.Input, je.IsHashLable)
 	if err != nil {
-		if retry {
+		if notify.IsRetryable(err) {
 			config.RequestError.WithLabelValues("retry-create", "500").Inc()
-			return "", errors.New("retry")
+			return failureResponse(res), &retryError{err: err}
 		}
 		config.RequestError.WithLabelValues("create", "500").Inc()
-		return "", err
+		return failureResponse(res), err
 	}
 	config.RequestError.WithLabelValues("create", "200").Inc()
-	return jsoniter.MarshalToString(map[string]interface{}{
+	return notifyResponse(res, receiver)
+}
+
+// UpdateIssues refreshes the summary, description and fields of the issue tracked for je.Group from je.Input.
+func (je Jiralert) UpdateIssues(ctx context.Context) (string, error) {
+	_, receiver, err := je.receiver(ctx, je.Config)
+	if err != nil {
+		return "", err
+	}
+	key, retry, err := receiver.Update(ctx, je.Group, je.Input)
+	return je.issueResult("update", receiver, key, retry, err)
+}
+
+// CloseIssues transitions the issue tracked for je.Group into je.State, or the receiver's auto_resolve state.
+func (je Jiralert) CloseIssues(ctx context.Context) (string, error) {
+	rc, receiver, err := je.receiver(ctx, je.Config)
+	if err != nil {
+		return "", err
+	}
+	state := je.State
+	if state == "" && rc.AutoResolve != nil {
+		state = rc.AutoResolve.State
+	}
+	if state == "" {
+		config.RequestError.WithLabelValues("close", "400").Inc()
+		return "", errors.Wrapf(ErrNoCloseState, "receiver %q", rc.Name)
+	}
+	key, retry, err := receiver.Close(ctx, je.Group, je.Input, state)
+	return je.issueResult("close", receiver, key, retry, err)
+}
+
+// receiver resolves the receiver of je.Input and builds its notify.Receiver.
+func (je Jiralert) receiver(ctx context.Context, conf *config.Config) (*config.ReceiverConfig, *notify.Receiver, error) {
+	rc, err := conf.ResolveReceiver(ctx, je.Input.Receiver)
+	if err != nil {
+		config.RequestError.WithLabelValues("receiver", "400").Inc()
+		return nil, nil, err
+	}
+	client, ok := je.Clients.Client(rc.Name)
+	if !ok {
+		config.RequestError.WithLabelValues("newclient", "500").Inc()
+		return nil, nil, errors.Errorf("no Jira client for receiver %q", rc.Name)
+	}
+	return rc, notify.NewReceiver(rc, je.Template, notify.NewIssueService(client), je.Locker), nil
+}
+
+func (je Jiralert) issueResult(op string, receiver *notify.Receiver, key string, retry bool, err error) (string, error) {
+	if err != nil {
+		if retry {
+			config.RequestError.WithLabelValues("retry-"+op, "500").Inc()
+			return "", &retryError{err: err}
+		}
+		config.RequestError.WithLabelValues(op, "500").Inc()
+		return "", err
+	}
+	config.RequestError.WithLabelValues(op, "200").Inc()
+	return successResponse(key, receiver)
+}
+
+// notifyResponse returns the body answering a notification that receiver handled with the result res.
+func notifyResponse(res *notify.NotifyResult, receiver *notify.Receiver) (string, error) {
+	resp := map[string]interface{}{
+		"code":      http.StatusOK,
+		"msg":       "success",
+		"issue_key": res.IssueKey,
+		"issue_url": res.IssueURL,
+		"action":    res.Action,
+		"api_calls": res.APICalls,
+	}
+	addPlannedActions(resp, receiver)
+	return jsoniter.MarshalToString(resp)
+}
+
+// failureResponse returns what a notification that failed did before failing, as res tells.
+func failureResponse(res *notify.NotifyResult) string {
+	body, err := jsoniter.MarshalToString(res)
+	if err != nil {
+		log.Errorf("failed to marshal notify result: %v", err)
+		return ""
+	}
+	return body
+}
+
+// successResponse returns the body answering a notification that receiver handled. If the receiver is in dry-run mode,
+// it lists the changes to Jira that were planned instead of made.
+func successResponse(key string, receiver *notify.Receiver) (string, error) {
+	resp := map[string]interface{}{
 		"code":      http.StatusOK,
 		"msg":       "success",
 		"issue_key": key,
-	})
+	}
+	addPlannedActions(resp, receiver)
+	return jsoniter.MarshalToString(resp)
+}
+
+// addPlannedActions adds the changes to Jira that receiver planned to resp, if it is in dry-run mode.
+func addPlannedActions(resp map[string]interface{}, receiver *notify.Receiver) {
+	if actions := receiver.PlannedActions(); actions != nil {
+		resp["dry_run"] = true
+		resp["planned_actions"] = actions
+	}
 }
 
 // Verify Config if not exist
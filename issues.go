@@ -0,0 +1,129 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const issuesAPIPath = "/api/v1/issues/"
+
+// IssuesHandlerFunc is the HTTP handler for `/api/v1/issues/`, which lets operators manage the issue tracked for an
+// alert group without going through Alertmanager:
+//
+//	POST /api/v1/issues/{group}/update?receiver=NAME          re-render summary, description and fields
+//	POST /api/v1/issues/{group}/close?receiver=NAME[&state=S] transition the issue into S, or the auto_resolve state
+//
+// {group} is the path-escaped Jira label JIRAlert put on the issue. The request body is an Alertmanager webhook
+// payload used as template input; it is required for update and optional for close. The receiver defaults to the
+// payload's receiver. locker should be that of the webhook, so that the issue is not changed while a notification
+// for the group is handled.
+func IssuesHandlerFunc(reloader *Reloader, locker notify.Locker) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), notifyTimeout)
+		defer func() {
+			_ = req.Body.Close()
+			cancel()
+		}()
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = w.Write([]byte("only POST allowed"))
+			return
+		}
+		path := strings.TrimPrefix(req.URL.EscapedPath(), issuesAPIPath)
+		i := strings.LastIndex(path, "/")
+		if i <= 0 {
+			http.NotFound(w, req)
+			return
+		}
+		group, err := url.PathUnescape(path[:i])
+		if err != nil {
+			errorHandler(w, http.StatusBadRequest, fmt.Errorf("invalid group %q: %v", path[:i], err))
+			return
+		}
+		op := path[i+1:]
+		if op != "update" && op != "close" {
+			http.NotFound(w, req)
+			return
+		}
+
+		data := &alertmanager.Data{}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to read request body: %v", err))
+			return
+		}
+		if len(body) > 0 {
+			if err := jsoniter.Unmarshal(body, data); err != nil {
+				errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to parse request body: %v", err))
+				return
+			}
+		} else if op == "update" {
+			errorHandler(w, http.StatusBadRequest, errors.New("update needs an Alertmanager webhook payload to render the issue from"))
+			return
+		}
+		if receiver := req.URL.Query().Get("receiver"); receiver != "" {
+			data.Receiver = receiver
+		}
+		if data.Receiver == "" {
+			errorHandler(w, http.StatusBadRequest, errors.New("missing receiver, pass it as ?receiver= or in the payload"))
+			return
+		}
+
+		snap := reloader.Snapshot()
+		je := Jiralert{
+			Input:    data,
+			Config:   snap.Config,
+			Template: snap.Template,
+			Clients:  snap.Clients,
+			Group:    group,
+			State:    req.URL.Query().Get("state"),
+			Locker:   locker,
+		}
+		var resp string
+		if op == "update" {
+			resp, err = je.UpdateIssues(ctx)
+		} else {
+			resp, err = je.CloseIssues(ctx)
+		}
+		if err != nil {
+			log.Errorf("failed to %s issue group:%s receiver:%s err:%v", op, group, data.Receiver, err)
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, config.ErrUnknownReceiver), errors.Is(err, ErrNoCloseState):
+				status = http.StatusBadRequest
+			case errors.Is(err, notify.ErrIssueNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, ErrRetry):
+				status = http.StatusServiceUnavailable
+			}
+			errorHandler(w, status, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(resp))
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuesHandlerFunc(t *testing.T) {
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config(`
+  - name: 'jira-xy'
+    project: XY
+    fields:
+      customfield_10001: '{{ .CommonLabels.team }}'
+    auto_resolve:
+      state: Done
+`)))
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	NewWebhook(reloader, true, nil, nil, nil).AlertHandlerFunc()(rec,
+		httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-xy"))))
+	require.Equal(t, http.StatusOK, rec.Code)
+	group := url.PathEscape(jira.Issue("XY-1").Label)
+
+	update := `{"receiver":"jira-xy","status":"firing","groupLabels":{"alertname":"A"},"commonLabels":{"alertname":"A","team":"sre"},` +
+		`"alerts":[{"status":"firing","labels":{"alertname":"A"}},{"status":"firing","labels":{"alertname":"A"}}]}`
+	h := IssuesHandlerFunc(reloader, nil)
+	for _, tcase := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		msg    string
+		check  func(t *testing.T, is *testIssue)
+	}{
+		{name: "method", method: http.MethodGet, path: group + "/close?receiver=jira-xy", code: http.StatusMethodNotAllowed},
+		{name: "unknown operation", path: group + "/reopen?receiver=jira-xy", code: http.StatusNotFound},
+		{name: "missing group", path: "close?receiver=jira-xy", code: http.StatusNotFound},
+		{name: "missing receiver", path: group + "/close", code: http.StatusBadRequest, msg: "missing receiver"},
+		{name: "unknown receiver", path: group + "/close?receiver=jira-nope", code: http.StatusBadRequest, msg: "unknown receiver"},
+		{name: "update without payload", path: group + "/update?receiver=jira-xy", code: http.StatusBadRequest, msg: "needs an Alertmanager webhook payload"},
+		{name: "unknown group", path: url.PathEscape("ALERT{x=\"y\"}") + "/close?receiver=jira-xy", code: http.StatusNotFound, msg: "no issue found"},
+		{name: "no close state", path: group + "/close?receiver=jira-ab", code: http.StatusBadRequest, msg: "no state to close the issue in"},
+		{
+			name: "update", path: group + "/update", body: update, code: http.StatusOK,
+			check: func(t *testing.T, is *testIssue) {
+				require.Equal(t, "[FIRING:2] A (sre)", strings.TrimSpace(is.Summary))
+				require.JSONEq(t, `"sre"`, string(is.Fields["customfield_10001"]))
+				require.Equal(t, "new", is.Status)
+			},
+		},
+		{
+			name: "close", path: group + "/close?receiver=jira-xy", code: http.StatusOK,
+			check: func(t *testing.T, is *testIssue) { require.Equal(t, "done", is.Status) },
+		},
+		{name: "close resolved issue", path: group + "/close?receiver=jira-xy&state=Unknown", code: http.StatusOK},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			if tcase.method == "" {
+				tcase.method = http.MethodPost
+			}
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(tcase.method, issuesAPIPath+tcase.path, strings.NewReader(tcase.body)))
+			require.Equal(t, tcase.code, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tcase.msg)
+			if tcase.code == http.StatusOK {
+				var resp struct {
+					IssueKey string `json:"issue_key"`
+				}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+				require.Equal(t, "XY-1", resp.IssueKey)
+			}
+			if tcase.check != nil {
+				tcase.check(t, jira.Issue("XY-1"))
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	journalFile = "journal.log"
+
+	// defaultCompactAfter is the number of acked records the journal file may accumulate before it is rewritten with
+	// only the pending entries.
+	defaultCompactAfter = 1000
+
+	journalOpAppend = "append"
+	journalOpAck    = "ack"
+)
+
+var errJournalClosed = errors.New("journal is closed")
+
+// JournalEntry is a notification that was accepted from Alertmanager but not yet delivered to Jira.
+type JournalEntry struct {
+	ID uint64
+	// Handler names the webhook endpoint that accepted the notification, so that it is replayed the same way.
+	Handler string
+	Data    *alertmanager.Data
+}
+
+// journalRecord is one line of the journal file, prefixed with the CRC32 of its JSON encoding.
+type journalRecord struct {
+	Op      string             `json:"op"`
+	ID      uint64             `json:"id"`
+	Handler string             `json:"handler,omitempty"`
+	Data    *alertmanager.Data `json:"data,omitempty"`
+}
+
+// Journal is a write-ahead log of accepted notifications. Entries are fsynced before the webhook is answered and acked
+// once Jira has been updated, so that notifications accepted before a crash or restart are replayed at startup.
+//
+// Acks are not fsynced: losing one only means the notification is replayed, which is harmless since notifying is
+// idempotent. Alertmanager sends the full state of an alert group with every notification, so acking an entry also
+// acks the older pending entries of the same group; replaying them would only roll the issue back to a stale state.
+type Journal struct {
+	dir          string
+	compactAfter int
+
+	mtx     sync.Mutex
+	f       *os.File
+	nextID  uint64
+	pending map[uint64]*JournalEntry
+	// records is the number of records in the journal file.
+	records int
+}
+
+// OpenJournal opens, or creates, the journal in the given directory and loads the entries that were never acked.
+// A torn record at the end of the file, left by a crash in the middle of a write, is discarded.
+func OpenJournal(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.Wrap(err, "create journal directory")
+	}
+	j := &Journal{
+		dir:          dir,
+		compactAfter: defaultCompactAfter,
+		nextID:       1,
+		pending:      map[uint64]*JournalEntry{},
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	// Start every run with a compact file, which also drops a torn tail.
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) path() string {
+	return filepath.Join(j.dir, journalFile)
+}
+
+func (j *Journal) load() error {
+	f, err := os.Open(j.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "open journal")
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Warnf("discarding torn record at the end of the journal path:%s bytes:%d", j.path(), len(line))
+			}
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read journal")
+		}
+		rec, err := decodeJournalRecord(line)
+		if err != nil {
+			log.Warnf("discarding the journal from the first corrupt record path:%s err:%v", j.path(), err)
+			return nil
+		}
+		j.apply(rec)
+	}
+}
+
+func (j *Journal) apply(rec *journalRecord) {
+	j.records++
+	if rec.ID >= j.nextID {
+		j.nextID = rec.ID + 1
+	}
+	switch rec.Op {
+	case journalOpAppend:
+		j.pending[rec.ID] = &JournalEntry{ID: rec.ID, Handler: rec.Handler, Data: rec.Data}
+	case journalOpAck:
+		delete(j.pending, rec.ID)
+	}
+}
+
+func encodeJournalRecord(rec *journalRecord) ([]byte, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(b), b)), nil
+}
+
+func decodeJournalRecord(line []byte) (*journalRecord, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) < 10 || line[8] != ' ' {
+		return nil, errors.New("malformed record")
+	}
+	var sum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil {
+		return nil, errors.Wrap(err, "malformed checksum")
+	}
+	if crc32.ChecksumIEEE(line[9:]) != sum {
+		return nil, errors.New("checksum mismatch")
+	}
+	rec := &journalRecord{}
+	if err := json.Unmarshal(line[9:], rec); err != nil {
+		return nil, errors.Wrap(err, "malformed record")
+	}
+	return rec, nil
+}
+
+// Append durably records a notification and returns its ID. The notification must be acked with Ack once delivered.
+func (j *Journal) Append(handler string, data *alertmanager.Data) (uint64, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	rec := &journalRecord{Op: journalOpAppend, ID: j.nextID, Handler: handler, Data: data}
+	if err := j.write(true, rec); err != nil {
+		return 0, errors.Wrap(err, "append to journal")
+	}
+	j.nextID++
+	j.pending[rec.ID] = &JournalEntry{ID: rec.ID, Handler: handler, Data: data}
+	j.updateMetrics()
+	return rec.ID, nil
+}
+
+// Ack marks the notification with the given ID, and the older pending notifications of the same alert group, as done.
+func (j *Journal) Ack(id uint64) error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	e, ok := j.pending[id]
+	if !ok {
+		return nil
+	}
+	recs := []*journalRecord{{Op: journalOpAck, ID: id}}
+	for _, p := range j.pending {
+		if p.ID < id && supersedes(e, p) {
+			recs = append(recs, &journalRecord{Op: journalOpAck, ID: p.ID})
+		}
+	}
+	if err := j.write(false, recs...); err != nil {
+		return errors.Wrap(err, "ack in journal")
+	}
+	for _, rec := range recs {
+		delete(j.pending, rec.ID)
+	}
+	j.updateMetrics()
+	if j.records-len(j.pending) >= j.compactAfter {
+		if err := j.compact(); err != nil {
+			log.Errorf("failed to compact journal path:%s err:%v", j.path(), err)
+		}
+	}
+	return nil
+}
+
+// group identifies the alert group of the entry, or is empty if Alertmanager did not send a group key.
+func (e *JournalEntry) group() string {
+	if e.Data.GroupKey == "" {
+		return ""
+	}
+	return e.Handler + "\x00" + e.Data.Receiver + "\x00" + e.Data.GroupKey
+}
+
+// supersedes reports whether e carries a newer state of the same alert group as p.
+func supersedes(e, p *JournalEntry) bool {
+	return e.group() != "" && e.group() == p.group()
+}
+
+// IsPending reports whether the notification with the given ID still waits for an ack.
+func (j *Journal) IsPending(id uint64) bool {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	_, ok := j.pending[id]
+	return ok
+}
+
+// Pending returns the notifications waiting for an ack, oldest first. Entries superseded by a newer pending entry of
+// the same alert group are left out.
+func (j *Journal) Pending() []*JournalEntry {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	entries := make([]*JournalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].ID < entries[b].ID })
+
+	// Walk newest first, keeping only the first entry seen for every alert group.
+	latest := make([]*JournalEntry, 0, len(entries))
+	groups := map[string]struct{}{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if k := e.group(); k != "" {
+			if _, ok := groups[k]; ok {
+				continue
+			}
+			groups[k] = struct{}{}
+		}
+		latest = append(latest, e)
+	}
+	for a, b := 0, len(latest)-1; a < b; a, b = a+1, b-1 {
+		latest[a], latest[b] = latest[b], latest[a]
+	}
+	return latest
+}
+
+// Close compacts the journal and closes its file.
+func (j *Journal) Close() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	if err := j.compact(); err != nil {
+		return err
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// write appends the records to the journal file with a single write call. It must be called with j.mtx held.
+func (j *Journal) write(sync bool, recs ...*journalRecord) error {
+	if j.f == nil {
+		return errJournalClosed
+	}
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		b, err := encodeJournalRecord(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	if _, err := j.f.Write(buf.Bytes()); err != nil {
+		config.JournalWriteErrors.Inc()
+		return err
+	}
+	j.records += len(recs)
+	if sync {
+		if err := j.f.Sync(); err != nil {
+			config.JournalWriteErrors.Inc()
+			return err
+		}
+	}
+	return nil
+}
+
+// compact atomically replaces the journal file with one that only holds the pending entries. It must be called with
+// j.mtx held.
+func (j *Journal) compact() error {
+	tmp := j.path() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return errors.Wrap(err, "create compacted journal")
+	}
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		e := j.pending[id]
+		b, err := encodeJournalRecord(&journalRecord{Op: journalOpAppend, ID: e.ID, Handler: e.Handler, Data: e.Data})
+		if err == nil {
+			_, err = w.Write(b)
+		}
+		if err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "write compacted journal")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "write compacted journal")
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "sync compacted journal")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close compacted journal")
+	}
+	if err := os.Rename(tmp, j.path()); err != nil {
+		return errors.Wrap(err, "replace journal")
+	}
+	if err := syncDir(j.dir); err != nil {
+		return errors.Wrap(err, "sync journal directory")
+	}
+
+	if j.f != nil {
+		_ = j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.path(), os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return errors.Wrap(err, "open journal")
+	}
+	j.records = len(ids)
+	config.JournalCompactions.Inc()
+	j.updateMetrics()
+	return nil
+}
+
+// updateMetrics must be called with j.mtx held.
+func (j *Journal) updateMetrics() {
+	config.JournalPending.Set(float64(len(j.pending)))
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/stretchr/testify/require"
+)
+
+func pendingIDs(j *Journal) []uint64 {
+	ids := []uint64{}
+	for _, e := range j.Pending() {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestJournal_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir)
+	require.NoError(t, err)
+
+	for _, g := range []string{"a", "b", "c"} {
+		_, err := j.Append(journalHandlerAlert, &alertmanager.Data{Receiver: "jira-ab", GroupKey: g})
+		require.NoError(t, err)
+	}
+	require.NoError(t, j.Ack(2))
+	require.Equal(t, []uint64{1, 3}, pendingIDs(j))
+
+	// Simulate a crash: the file is not closed, and the last write was torn.
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`0badc0de {"op":"append","id":4`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, err = OpenJournal(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3}, pendingIDs(j))
+	require.Equal(t, "c", j.Pending()[1].Data.GroupKey)
+
+	// IDs keep increasing across runs, so that acks in the new run never hit entries of the old one.
+	id, err := j.Append(journalHandlerAlert, &alertmanager.Data{GroupKey: "d"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), id)
+	require.NoError(t, j.Close())
+
+	b, err := os.ReadFile(filepath.Join(dir, journalFile))
+	require.NoError(t, err)
+	require.Equal(t, 3, bytes.Count(b, []byte("\n")))
+}
+
+func TestJournal_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := j.Append(journalHandlerAlert, &alertmanager.Data{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, j.Close())
+
+	path := filepath.Join(dir, journalFile)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(b), "\n")
+	lines[1] = strings.Replace(lines[1], `"id":2`, `"id":7`, 1)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "")), 0o640))
+
+	// Everything from the first record failing its checksum is discarded.
+	j, err = OpenJournal(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, pendingIDs(j))
+}
+
+func TestJournal_Supersede(t *testing.T) {
+	j, err := OpenJournal(t.TempDir())
+	require.NoError(t, err)
+
+	for _, d := range []*alertmanager.Data{
+		{Receiver: "jira-ab", GroupKey: "a"},
+		{Receiver: "jira-ab", GroupKey: "b"},
+		{Receiver: "jira-xy", GroupKey: "a"},
+		{Receiver: "jira-ab", GroupKey: "a"},
+		{Receiver: "jira-ab"},
+		{Receiver: "jira-ab"},
+	} {
+		_, err := j.Append(journalHandlerAlert, d)
+		require.NoError(t, err)
+	}
+	// Only the latest state of a group is replayed; notifications without a group key are never merged.
+	require.Equal(t, []uint64{2, 3, 4, 5, 6}, pendingIDs(j))
+
+	require.NoError(t, j.Ack(4))
+	require.False(t, j.IsPending(1))
+	require.True(t, j.IsPending(3))
+	require.NoError(t, j.Ack(6))
+	require.Equal(t, []uint64{2, 3, 5}, pendingIDs(j))
+}
+
+func TestJournal_Compact(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir)
+	require.NoError(t, err)
+	j.compactAfter = 10
+
+	keep, err := j.Append(journalHandlerAlert, &alertmanager.Data{})
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		id, err := j.Append(journalHandlerAlert, &alertmanager.Data{})
+		require.NoError(t, err)
+		require.NoError(t, j.Ack(id))
+	}
+	require.Less(t, j.records, 11)
+
+	j, err = OpenJournal(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{keep}, pendingIDs(j))
+}
+
+func TestWebhook_Journal(t *testing.T) {
+	jira := newTestJira(t)
+	jira.failWith.Store(http.StatusServiceUnavailable)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config("")))
+	require.NoError(t, err)
+	dir := t.TempDir()
+	journal, err := OpenJournal(dir)
+	require.NoError(t, err)
+
+	h := NewWebhook(reloader, true, nil, journal, nil).DeprecatedAlertHandlerFunc()
+	body := testAlertBody("jira-ab")
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alert/deprecated", strings.NewReader(body)))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.NoError(t, journal.Close())
+
+	// The retryable failure is kept across a restart and replayed through Notify once Jira is back.
+	journal, err = OpenJournal(dir)
+	require.NoError(t, err)
+	require.Len(t, journal.Pending(), 1)
+	jira.failWith.Store(0)
+	NewWebhook(reloader, true, nil, journal, nil).Replay(context.Background())
+	require.Equal(t, []string{"AB"}, jira.Created())
+	require.Empty(t, journal.Pending())
+
+	// Notifications failing for good are acked too, Alertmanager would not get a different answer on retry.
+	jira.failWith.Store(http.StatusBadRequest)
+	rec = httptest.NewRecorder()
+	h = NewWebhook(reloader, true, nil, journal, nil).DeprecatedAlertHandlerFunc()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alert/deprecated", strings.NewReader(body)))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Empty(t, journal.Pending())
+}
@@ -15,10 +15,13 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/trivago/tgo/tcontainer"
 	"gopkg.in/yaml.v2"
+	"math"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -112,11 +115,290 @@ func resolveFilepaths(baseDir string, cfg *Config) {
 	}
 
 	cfg.Template = join(cfg.Template)
+	// Receivers without a tls_config share the one of the defaults, which must only be resolved once.
+	resolved := map[*TLSConfig]bool{}
+	for _, rc := range append([]*ReceiverConfig{cfg.Defaults}, cfg.Receivers...) {
+		if rc == nil || rc.TLSConfig == nil || resolved[rc.TLSConfig] {
+			continue
+		}
+		resolved[rc.TLSConfig] = true
+		rc.TLSConfig.CAFile = join(rc.TLSConfig.CAFile)
+		rc.TLSConfig.CertFile = join(rc.TLSConfig.CertFile)
+		rc.TLSConfig.KeyFile = join(rc.TLSConfig.KeyFile)
+	}
+}
+
+// The values of description_update.
+const (
+	// DescriptionUpdateAlways sets the description on creation and rewrites it whenever it changes.
+	DescriptionUpdateAlways = "always"
+	// DescriptionUpdateOnCreate only sets the description on creation.
+	DescriptionUpdateOnCreate = "on_create"
+	// DescriptionUpdateNever leaves the description to Jira and the issue's users.
+	DescriptionUpdateNever = "never"
+)
+
+func validDescriptionUpdate(v string) bool {
+	switch v {
+	case "", DescriptionUpdateAlways, DescriptionUpdateOnCreate, DescriptionUpdateNever:
+		return true
+	}
+	return false
 }
 
 // AutoResolve is the struct used for defining jira resolution state when alert is resolved.
 type AutoResolve struct {
 	State string `yaml:"state"`
+	// Resolution is the name of the resolution set by the transition. Optional; the workflow picks one otherwise.
+	Resolution string `yaml:"resolution,omitempty" json:"resolution,omitempty"`
+	// Comment is the template of the comment added by the transition. Optional.
+	Comment string `yaml:"comment,omitempty" json:"comment,omitempty"`
+}
+
+// TLSConfig configures the TLS connections to Jira. It is modeled on Prometheus' tls_config.
+type TLSConfig struct {
+	// CA certificate to validate the Jira server certificate with, instead of the system roots.
+	CAFile string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	// Certificate and key for client certificate authentication (mTLS).
+	CertFile string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	// ServerName overrides the name the server certificate is validated against, and is sent as SNI.
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+	// Disable validation of the server certificate.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (tc *TLSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TLSConfig
+	if err := unmarshal((*plain)(tc)); err != nil {
+		return err
+	}
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must both be set for client certificate authentication")
+	}
+	return checkOverflow(tc.XXX, "tls_config")
+}
+
+// HTTPConfig configures the HTTP client used to talk to Jira.
+type HTTPConfig struct {
+	// ProxyURL is the proxy requests to Jira go through. Without it, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables are honored.
+	ProxyURL string `yaml:"proxy_url,omitempty" json:"proxy_url,omitempty"`
+	// NoProxy is a comma-separated list of hosts, domains, IPs or CIDRs that are reached without ProxyURL, with the
+	// same syntax as NO_PROXY.
+	NoProxy string `yaml:"no_proxy,omitempty" json:"no_proxy,omitempty"`
+
+	DialTimeout           Duration `yaml:"dial_timeout,omitempty" json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   Duration `yaml:"tls_handshake_timeout,omitempty" json:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout Duration `yaml:"response_header_timeout,omitempty" json:"response_header_timeout,omitempty"`
+	MaxIdleConnsPerHost   int      `yaml:"max_idle_conns_per_host,omitempty" json:"max_idle_conns_per_host,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (hc *HTTPConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain HTTPConfig
+	if err := unmarshal((*plain)(hc)); err != nil {
+		return err
+	}
+	if hc.ProxyURL != "" {
+		u, err := url.Parse(hc.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy_url: %s", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid proxy_url %q: scheme must be http, https or socks5", u.Redacted())
+		}
+		if u.Host == "" {
+			return fmt.Errorf("invalid proxy_url %q: missing host", u.Redacted())
+		}
+	} else if hc.NoProxy != "" {
+		return fmt.Errorf("no_proxy requires proxy_url")
+	}
+	if hc.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("max_idle_conns_per_host must not be negative")
+	}
+	return checkOverflow(hc.XXX, "http_config")
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, redacting the password of the proxy URL.
+func (hc HTTPConfig) MarshalYAML() (interface{}, error) {
+	type plain HTTPConfig
+	p := plain(hc)
+	if u, err := url.Parse(p.ProxyURL); err == nil {
+		p.ProxyURL = u.Redacted()
+	}
+	return p, nil
+}
+
+// RateLimitConfig limits the requests sent to a Jira instance. All receivers talking to the instance share the limit.
+type RateLimitConfig struct {
+	// Rate is the sustained number of requests per second.
+	Rate float64 `yaml:"rate" json:"rate"`
+	// Burst is the number of requests that may be sent at once after a quiet period. Optional (default: Rate rounded
+	// up).
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (rl *RateLimitConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RateLimitConfig
+	if err := unmarshal((*plain)(rl)); err != nil {
+		return err
+	}
+	if rl.Rate <= 0 {
+		return fmt.Errorf("rate in rate_limit must be positive")
+	}
+	if rl.Burst < 0 {
+		return fmt.Errorf("burst in rate_limit must not be negative")
+	}
+	if rl.Burst == 0 {
+		rl.Burst = int(math.Ceil(rl.Rate))
+	}
+	return checkOverflow(rl.XXX, "rate_limit")
+}
+
+// sameRateLimit tells whether a and b, either of which may be nil, set the same limit.
+func sameRateLimit(a, b *RateLimitConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Rate == b.Rate && a.Burst == b.Burst
+}
+
+// CircuitBreakerConfig configures the circuit breaker that stops requests to a Jira instance that keeps failing. All
+// receivers talking to the instance share it.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the circuit. Optional (default: 5).
+	FailureThreshold int `yaml:"failure_threshold,omitempty" json:"failure_threshold,omitempty"`
+	// OpenDuration is how long requests fail at once before a single probe request is let through. Optional (default:
+	// 30s).
+	OpenDuration Duration `yaml:"open_duration,omitempty" json:"open_duration,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// DefaultCircuitBreaker is the circuit breaker of receivers that do not configure one.
+var DefaultCircuitBreaker = CircuitBreakerConfig{FailureThreshold: 5, OpenDuration: Duration(30 * time.Second)}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (cb *CircuitBreakerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain CircuitBreakerConfig
+	if err := unmarshal((*plain)(cb)); err != nil {
+		return err
+	}
+	if cb.FailureThreshold < 0 {
+		return fmt.Errorf("failure_threshold in circuit_breaker must not be negative")
+	}
+	if cb.FailureThreshold == 0 {
+		cb.FailureThreshold = DefaultCircuitBreaker.FailureThreshold
+	}
+	if cb.OpenDuration == 0 {
+		cb.OpenDuration = DefaultCircuitBreaker.OpenDuration
+	}
+	return checkOverflow(cb.XXX, "circuit_breaker")
+}
+
+// sameCircuitBreaker tells whether a and b configure the same circuit breaker.
+func sameCircuitBreaker(a, b *CircuitBreakerConfig) bool {
+	return a.FailureThreshold == b.FailureThreshold && a.OpenDuration == b.OpenDuration
+}
+
+// WebhookAuth configures the authentication of inbound requests to the webhook and issue endpoints. Requests must come
+// from one of AllowedCIDRs if any are set, carry BearerToken or BasicAuth credentials if either is set, and be signed
+// with HMACSecret if it is set.
+type WebhookAuth struct {
+	BearerToken Secret     `yaml:"bearer_token,omitempty" json:"bearer_token,omitempty"`
+	BasicAuth   *BasicAuth `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+	// HMACSecret is the key of the HMAC-SHA256 signature of the request body, sent hex encoded in SignatureHeader.
+	HMACSecret      Secret `yaml:"hmac_secret,omitempty" json:"hmac_secret,omitempty"`
+	SignatureHeader string `yaml:"signature_header,omitempty" json:"signature_header,omitempty"`
+	// MaxBodyBytes bounds the request body read to check the signature, before the request is authenticated.
+	MaxBodyBytes int64 `yaml:"max_body_bytes,omitempty" json:"max_body_bytes,omitempty"`
+	// AllowedCIDRs are the networks requests are accepted from, matched against the peer address of the connection.
+	AllowedCIDRs []string `yaml:"allowed_cidrs,omitempty" json:"allowed_cidrs,omitempty"`
+
+	allowedNets []*net.IPNet
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// DefaultSignatureHeader is the header carrying the request signature if signature_header is not set.
+const DefaultSignatureHeader = "X-Jiralert-Signature"
+
+// DefaultMaxBodyBytes is the largest signed request body accepted if max_body_bytes is not set.
+const DefaultMaxBodyBytes = 10 << 20
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (wa *WebhookAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain WebhookAuth
+	if err := unmarshal((*plain)(wa)); err != nil {
+		return err
+	}
+	if wa.SignatureHeader == "" {
+		wa.SignatureHeader = DefaultSignatureHeader
+	}
+	if wa.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes in webhook_auth cannot be negative")
+	}
+	if wa.MaxBodyBytes == 0 {
+		wa.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	for _, cidr := range wa.AllowedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid allowed_cidrs entry %q in webhook_auth: %s", cidr, err)
+		}
+		wa.allowedNets = append(wa.allowedNets, ipNet)
+	}
+	return checkOverflow(wa.XXX, "webhook_auth")
+}
+
+// AllowsIP reports whether requests from ip are accepted by AllowedCIDRs.
+func (wa *WebhookAuth) AllowsIP(ip net.IP) bool {
+	if len(wa.allowedNets) == 0 {
+		return true
+	}
+	for _, ipNet := range wa.allowedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// BasicAuth holds the credentials of HTTP basic authentication.
+type BasicAuth struct {
+	Username string `yaml:"username" json:"username"`
+	Password Secret `yaml:"password" json:"password,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (ba *BasicAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain BasicAuth
+	if err := unmarshal((*plain)(ba)); err != nil {
+		return err
+	}
+	if ba.Username == "" || ba.Password == "" {
+		return fmt.Errorf("basic_auth requires username and password")
+	}
+	return checkOverflow(ba.XXX, "basic_auth")
 }
 
 // ReceiverConfig is the configuration for one receiver. It has a unique name and includes API access fields (url and
@@ -125,10 +407,22 @@ type ReceiverConfig struct {
 	Name string `yaml:"name" json:"name"`
 
 	// API access fields
-	APIURL              string `yaml:"api_url,omitempty" json:"apiurl,omitempty"`
-	User                string `yaml:"user,omitempty" json:"user,omitempty"`
-	Password            Secret `yaml:"password,omitempty" json:"password,omitempty"`
-	PersonalAccessToken Secret `yaml:"personal_access_token" json:"personal_access_token,omitempty"`
+	APIURL              string      `yaml:"api_url,omitempty" json:"apiurl,omitempty"`
+	User                string      `yaml:"user,omitempty" json:"user,omitempty"`
+	Password            Secret      `yaml:"password,omitempty" json:"password,omitempty"`
+	PersonalAccessToken Secret      `yaml:"personal_access_token" json:"personal_access_token,omitempty"`
+	Email               string      `yaml:"email,omitempty" json:"email,omitempty"`
+	APIToken            Secret      `yaml:"api_token,omitempty" json:"api_token,omitempty"`
+	TLSConfig           *TLSConfig  `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+	HTTPConfig          *HTTPConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+	// RateLimit limits the requests to the Jira instance of APIURL, together with the other receivers talking to it.
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	// CircuitBreaker configures the circuit breaker of the Jira instance of APIURL, which the other receivers talking
+	// to it share. Defaults to DefaultCircuitBreaker.
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// APIVersion is the version of the Jira REST API to use, 2 or 3. Version 3 takes descriptions in the Atlassian
+	// Document Format, as required by Jira Cloud projects.
+	APIVersion int `yaml:"api_version,omitempty" json:"api_version,omitempty"`
 
 	// Required issue fields
 	Project        string    `yaml:"project,omitempty" json:"project,omitempty"`
@@ -142,6 +436,12 @@ type ReceiverConfig struct {
 	Description       string `yaml:"description" json:"description,omitempty"`
 	WontFixResolution string `yaml:"wont_fix_resolution,omitempty" json:"wont_fix_resolution,omitempty"`
 
+	// DescriptionUpdate tells when the description is written: always, on_create or never. Defaults to always.
+	DescriptionUpdate string `yaml:"description_update,omitempty" json:"description_update,omitempty"`
+	// Comment is the template of the comment added to an existing issue when alerts join the group or resolve, or
+	// when the issue is reopened. Optional; without it no comments are added.
+	Comment string `yaml:"comment,omitempty" json:"comment,omitempty"`
+
 	Fields     map[string]interface{} `yaml:"fields" json:"fields,omitempty"`
 	Components []string               `yaml:"components" json:"components,omitempty"`
 
@@ -150,6 +450,13 @@ type ReceiverConfig struct {
 
 	// Flag to auto-resolve opened issue when the alert is resolved.
 	AutoResolve *AutoResolve `yaml:"auto_resolve" json:"auto_resolve" json:"auto_resolve,omitempty"`
+	// MaxTransitionHops bounds the transitions made to bring an issue into the reopen_state or the auto_resolve state,
+	// when the workflow has no direct transition into it. Defaults to 1, the direct transition only.
+	MaxTransitionHops int `yaml:"max_transition_hops,omitempty" json:"max_transition_hops,omitempty"`
+
+	// DryRun makes the receiver only look issues up, and log and return the changes it would make to Jira instead of
+	// making them.
+	DryRun bool `yaml:"dry_run,omitempty" json:"dry_run,omitempty"`
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-" json:"xxx,omitempty"`
@@ -171,12 +478,37 @@ func (rc *ReceiverConfig) UnmarshalYAML(unmarshal func(interface{}) error) error
 	return checkOverflow(rc.XXX, "receiver")
 }
 
+// authMethods returns the authentication methods rc sets fields of.
+func authMethods(rc *ReceiverConfig) []string {
+	var methods []string
+	if rc.User != "" || rc.Password != "" {
+		methods = append(methods, "user/password")
+	}
+	if rc.PersonalAccessToken != "" {
+		methods = append(methods, "PAT")
+	}
+	if rc.Email != "" || rc.APIToken != "" {
+		methods = append(methods, "email/api_token")
+	}
+	return methods
+}
+
 // Config is the top-level configuration for JIRAlert's config file.
 type Config struct {
 	Defaults  *ReceiverConfig   `yaml:"defaults,omitempty"`
 	Receivers []*ReceiverConfig `yaml:"receivers,omitempty"`
 	Template  string            `yaml:"template"`
 
+	// FallbackReceiver names the receiver that gets notifications for receivers that are not configured. Optional;
+	// without it such notifications are rejected.
+	FallbackReceiver string `yaml:"fallback_receiver,omitempty"`
+
+	// WebhookAuth authenticates inbound requests. Optional; without it requests are not authenticated.
+	WebhookAuth *WebhookAuth `yaml:"webhook_auth,omitempty"`
+
+	// DryRun puts every receiver in dry-run mode, see ReceiverConfig.DryRun.
+	DryRun bool `yaml:"dry_run,omitempty"`
+
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
@@ -195,15 +527,21 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// To make unmarshal fill the plain data struct rather than calling UnmarshalYAML
 	// again, we have to hide it using a type indirection.
 
-	// TODO: This function panics when there are no defaults. This needs to be fixed.
-
 	type plain Config
 	if err := unmarshal((*plain)(c)); err != nil {
 		return err
 	}
+	if c.Defaults == nil {
+		c.Defaults = &ReceiverConfig{}
+	}
 
-	if (c.Defaults.User != "" || c.Defaults.Password != "") && c.Defaults.PersonalAccessToken != "" {
-		return fmt.Errorf("bad auth config in defaults section: user/password and PAT authentication are mutually exclusive")
+	if methods := authMethods(c.Defaults); len(methods) > 1 {
+		return fmt.Errorf("bad auth config in defaults section: %s authentication are mutually exclusive", strings.Join(methods, " and "))
+	}
+	switch c.Defaults.APIVersion {
+	case 0, 2, 3:
+	default:
+		return fmt.Errorf("bad config in defaults section: api_version must be 2 or 3")
 	}
 
 	if c.Defaults.AutoResolve != nil {
@@ -211,6 +549,12 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			return fmt.Errorf("bad config in defaults section: state cannot be empty")
 		}
 	}
+	if !validDescriptionUpdate(c.Defaults.DescriptionUpdate) {
+		return fmt.Errorf("bad config in defaults section: description_update must be always, on_create or never")
+	}
+	if c.Defaults.MaxTransitionHops < 0 {
+		return fmt.Errorf("bad config in defaults section: max_transition_hops cannot be negative")
+	}
 
 	for _, rc := range c.Receivers {
 		if rc.Name == "" {
@@ -227,12 +571,50 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if _, err := url.Parse(rc.APIURL); err != nil {
 			return fmt.Errorf("invalid api_url %q in receiver %q: %s", rc.APIURL, rc.Name, err)
 		}
+		if rc.TLSConfig == nil {
+			rc.TLSConfig = c.Defaults.TLSConfig
+		}
+		if rc.HTTPConfig == nil {
+			rc.HTTPConfig = c.Defaults.HTTPConfig
+		}
+		if rc.RateLimit == nil {
+			rc.RateLimit = c.Defaults.RateLimit
+		}
+		if rc.CircuitBreaker == nil {
+			rc.CircuitBreaker = c.Defaults.CircuitBreaker
+			if rc.CircuitBreaker == nil {
+				cb := DefaultCircuitBreaker
+				rc.CircuitBreaker = &cb
+			}
+		}
+		rc.DryRun = rc.DryRun || c.Defaults.DryRun || c.DryRun
 
-		if (rc.User != "" || rc.Password != "") && rc.PersonalAccessToken != "" {
-			return fmt.Errorf("bad auth config in receiver %q: user/password and PAT authentication are mutually exclusive", rc.Name)
+		switch rc.APIVersion {
+		case 0:
+			rc.APIVersion = c.Defaults.APIVersion
+			if rc.APIVersion == 0 {
+				rc.APIVersion = 2
+			}
+		case 2, 3:
+		default:
+			return fmt.Errorf("bad config in receiver %q: api_version must be 2 or 3", rc.Name)
 		}
 
-		if (rc.User == "" || rc.Password == "") && rc.PersonalAccessToken == "" {
+		if methods := authMethods(rc); len(methods) > 1 {
+			return fmt.Errorf("bad auth config in receiver %q: %s authentication are mutually exclusive", rc.Name, strings.Join(methods, " and "))
+		}
+
+		if rc.Email != "" || rc.APIToken != "" {
+			if rc.Email == "" {
+				rc.Email = c.Defaults.Email
+			}
+			if rc.APIToken == "" {
+				rc.APIToken = c.Defaults.APIToken
+			}
+			if rc.Email == "" || rc.APIToken == "" {
+				return fmt.Errorf("missing authentication in receiver %q: email and api_token must both be set", rc.Name)
+			}
+		} else if (rc.User == "" || rc.Password == "") && rc.PersonalAccessToken == "" {
 			if rc.User == "" && c.Defaults.User != "" {
 				rc.User = c.Defaults.User
 			}
@@ -245,6 +627,9 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 				// Nothing to do, we're ready to go with basic auth.
 			} else if c.Defaults.PersonalAccessToken != "" {
 				rc.PersonalAccessToken = c.Defaults.PersonalAccessToken
+			} else if c.Defaults.Email != "" && c.Defaults.APIToken != "" {
+				rc.Email = c.Defaults.Email
+				rc.APIToken = c.Defaults.APIToken
 			} else {
 				return fmt.Errorf("missing authentication in receiver %q", rc.Name)
 			}
@@ -292,6 +677,18 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if rc.WontFixResolution == "" && c.Defaults.WontFixResolution != "" {
 			rc.WontFixResolution = c.Defaults.WontFixResolution
 		}
+		if rc.DescriptionUpdate == "" {
+			rc.DescriptionUpdate = c.Defaults.DescriptionUpdate
+			if rc.DescriptionUpdate == "" {
+				rc.DescriptionUpdate = DescriptionUpdateAlways
+			}
+		}
+		if !validDescriptionUpdate(rc.DescriptionUpdate) {
+			return fmt.Errorf("bad config in receiver %q: description_update must be always, on_create or never", rc.Name)
+		}
+		if rc.Comment == "" && c.Defaults.Comment != "" {
+			rc.Comment = c.Defaults.Comment
+		}
 		if rc.AutoResolve != nil {
 			if rc.AutoResolve.State == "" {
 				return fmt.Errorf("bad config in receiver %q, 'auto_resolve' was defined with empty 'state' field", rc.Name)
@@ -300,6 +697,15 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if rc.AutoResolve == nil && c.Defaults.AutoResolve != nil {
 			rc.AutoResolve = c.Defaults.AutoResolve
 		}
+		if rc.MaxTransitionHops < 0 {
+			return fmt.Errorf("bad config in receiver %q: max_transition_hops cannot be negative", rc.Name)
+		}
+		if rc.MaxTransitionHops == 0 {
+			rc.MaxTransitionHops = c.Defaults.MaxTransitionHops
+			if rc.MaxTransitionHops == 0 {
+				rc.MaxTransitionHops = 1
+			}
+		}
 		if len(c.Defaults.Fields) > 0 {
 			for key, value := range c.Defaults.Fields {
 				if _, ok := rc.Fields[key]; !ok {
@@ -313,10 +719,28 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("no receivers defined")
 	}
 
+	// Receivers talking to the same Jira instance share one rate limiter and one circuit breaker.
+	byInstance := map[string]*ReceiverConfig{}
+	for _, rc := range c.Receivers {
+		u, _ := url.Parse(rc.APIURL)
+		instance := u.Scheme + "://" + u.Host
+		if other, ok := byInstance[instance]; !ok {
+			byInstance[instance] = rc
+		} else if !sameRateLimit(other.RateLimit, rc.RateLimit) {
+			return fmt.Errorf("receivers %q and %q talk to %s but set different rate_limit", other.Name, rc.Name, instance)
+		} else if !sameCircuitBreaker(other.CircuitBreaker, rc.CircuitBreaker) {
+			return fmt.Errorf("receivers %q and %q talk to %s but set different circuit_breaker", other.Name, rc.Name, instance)
+		}
+	}
+
 	if c.Template == "" {
 		return fmt.Errorf("missing template file")
 	}
 
+	if c.FallbackReceiver != "" && c.ReceiverByName(context.Background(), c.FallbackReceiver) == nil {
+		return fmt.Errorf("fallback_receiver %q is not a defined receiver", c.FallbackReceiver)
+	}
+
 	return checkOverflow(c.XXX, "config")
 }
 
@@ -334,6 +758,23 @@ func (c *Config) ReceiverByName(ctx context.Context, name string) *ReceiverConfi
 	return rc
 }
 
+// ErrUnknownReceiver is returned by ResolveReceiver for a receiver that is neither configured nor covered by
+// fallback_receiver.
+var ErrUnknownReceiver = errors.New("unknown receiver")
+
+// ResolveReceiver returns the configuration of the named receiver, falling back to fallback_receiver if it is not
+// configured.
+func (c *Config) ResolveReceiver(ctx context.Context, name string) (*ReceiverConfig, error) {
+	if rc := c.ReceiverByName(ctx, name); rc != nil {
+		return rc, nil
+	}
+	if c.FallbackReceiver == "" {
+		return nil, fmt.Errorf("%w %q: not in the configuration and no fallback_receiver is set", ErrUnknownReceiver, name)
+	}
+	log.Warnf("receiver not configured, using fallback receiver receiver:%s fallback:%s", name, c.FallbackReceiver)
+	return c.ReceiverByName(ctx, c.FallbackReceiver), nil
+}
+
 func checkOverflow(m map[string]interface{}, ctx string) error {
 	if len(m) > 0 {
 		var keys []string
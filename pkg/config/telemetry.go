@@ -29,9 +29,159 @@ var (
 			Help: "Requests processed, by receiver response error.",
 		},
 		[]string{"type", "code"})
+	ConfigLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful.",
+		},
+	)
+	ConfigLastReloadSuccessTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload.",
+		},
+	)
+	JiraClientPoolReceivers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_jira_client_pool_receivers",
+			Help: "Receivers with a Jira client in the active client pool.",
+		},
+	)
+	JiraClientPoolTransports = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_jira_client_pool_transports",
+			Help: "Distinct HTTP transports, each with its own keep-alive connection pool, in the active client pool.",
+		},
+	)
+	JiraConnectionsOpened = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jiralert_jira_connections_opened_total",
+			Help: "Connections dialed to Jira, by host.",
+		},
+		[]string{"host"},
+	)
+	JiraConnectionsOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jiralert_jira_connections_open",
+			Help: "Currently open connections to Jira, by host.",
+		},
+		[]string{"host"},
+	)
+	JiraRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jiralert_jira_retries_total",
+			Help: "Jira requests retried after a retryable failure, by API.",
+		},
+		[]string{"api"},
+	)
+	JiraRateLimitWaitSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "jiralert_jira_rate_limit_wait_seconds",
+			Help:    "Time Jira requests waited for the rate limit of their Jira instance, by host.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		[]string{"host"},
+	)
+	JiraRateLimitRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jiralert_jira_rate_limit_rejected_total",
+			Help: "Jira requests not sent because the rate limit would have delayed them past their deadline, by host.",
+		},
+		[]string{"host"},
+	)
+	JiraCircuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jiralert_jira_circuit_state",
+			Help: "State of the circuit breaker of each Jira instance: 0 closed, 1 open, 2 half-open.",
+		},
+		[]string{"instance"},
+	)
+	QueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_queue_depth",
+			Help: "Notifications waiting in the asynchronous queue.",
+		},
+	)
+	QueueOldestEnqueuedTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_queue_oldest_enqueued_timestamp_seconds",
+			Help: "Enqueue timestamp of the oldest notification waiting in the asynchronous queue, 0 if it is empty.",
+		},
+	)
+	QueueWaitSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "jiralert_queue_wait_seconds",
+			Help:    "Time notifications spent in the asynchronous queue before a worker picked them up.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+	)
+	QueueDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jiralert_queue_dropped_total",
+			Help: "Notifications dropped by the asynchronous queue, by reason.",
+		},
+		[]string{"reason"},
+	)
+	QueueRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jiralert_queue_retries_total",
+			Help: "Notification attempts retried by the asynchronous queue workers.",
+		},
+	)
+	JournalPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jiralert_journal_pending_entries",
+			Help: "Notifications recorded in the journal that were not yet delivered to Jira.",
+		},
+	)
+	JournalReplayed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jiralert_journal_replayed_total",
+			Help: "Notifications replayed from the journal at startup.",
+		},
+	)
+	JournalCompactions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jiralert_journal_compactions_total",
+			Help: "Rewrites of the journal file that dropped acked entries.",
+		},
+	)
+	JournalWriteErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "jiralert_journal_write_errors_total",
+			Help: "Failed writes or fsyncs of the journal file.",
+		},
+	)
+	WebhookAuthFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jiralert_webhook_auth_failures_total",
+			Help: "Inbound requests rejected by webhook_auth, by reason: ip, credentials or signature.",
+		},
+		[]string{"reason"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(RequestTotal)
 	prometheus.MustRegister(RequestError)
+	prometheus.MustRegister(ConfigLastReloadSuccessful)
+	prometheus.MustRegister(ConfigLastReloadSuccessTime)
+	prometheus.MustRegister(JiraClientPoolReceivers)
+	prometheus.MustRegister(JiraClientPoolTransports)
+	prometheus.MustRegister(JiraConnectionsOpened)
+	prometheus.MustRegister(JiraConnectionsOpen)
+	prometheus.MustRegister(JiraRetries)
+	prometheus.MustRegister(JiraRateLimitWaitSeconds)
+	prometheus.MustRegister(JiraRateLimitRejected)
+	prometheus.MustRegister(JiraCircuitState)
+	prometheus.MustRegister(QueueDepth)
+	prometheus.MustRegister(QueueOldestEnqueuedTime)
+	prometheus.MustRegister(QueueWaitSeconds)
+	prometheus.MustRegister(QueueDropped)
+	prometheus.MustRegister(QueueRetries)
+	prometheus.MustRegister(JournalPending)
+	prometheus.MustRegister(JournalReplayed)
+	prometheus.MustRegister(JournalCompactions)
+	prometheus.MustRegister(JournalWriteErrors)
+	prometheus.MustRegister(WebhookAuthFailures)
 }
@@ -0,0 +1,285 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jiraclient builds the Jira clients used to talk to each receiver's Jira instance, once per configuration
+// load, so that keep-alive connections are reused across notifications.
+package jiraclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultMaxIdleConnsPerHost = 20
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// Registry holds one Jira client per receiver. Receivers that talk to the same Jira instance share the underlying
+// transport and therefore its keep-alive connection pool, as well as its rate limiter.
+type Registry struct {
+	clients    map[string]*jira.Client
+	transports map[string]*http.Transport
+}
+
+// NewRegistry builds a client for every receiver in the given configuration.
+func NewRegistry(conf *config.Config) (*Registry, error) {
+	r := &Registry{
+		clients:    make(map[string]*jira.Client, len(conf.Receivers)),
+		transports: map[string]*http.Transport{},
+	}
+	for _, rc := range conf.Receivers {
+		host, err := apiHost(rc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "receiver %q", rc.Name)
+		}
+		key := transportKey(host, rc)
+		tr, ok := r.transports[key]
+		if !ok {
+			tlsConfig, err := NewTLSConfig(rc.TLSConfig)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid tls_config in receiver %q", rc.Name)
+			}
+			tr = newTransport(host, tlsConfig, rc.HTTPConfig)
+			r.transports[key] = tr
+		}
+		var rt http.RoundTripper = tr
+		if rc.APIVersion == 3 {
+			rt = apiV3Transport{next: tr}
+		}
+		if rc.RateLimit != nil {
+			rt = &rateLimitTransport{host: host, limiter: limiterFor(host, rc.RateLimit), next: rt}
+		}
+		client, err := jira.NewClient(authClient(rc, rt), rc.APIURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create Jira client for receiver %q", rc.Name)
+		}
+		r.clients[rc.Name] = client
+	}
+	return r, nil
+}
+
+// Client returns the Jira client of the named receiver.
+func (r *Registry) Client(receiver string) (*jira.Client, bool) {
+	c, ok := r.clients[receiver]
+	return c, ok
+}
+
+// Activate publishes the pool metrics for this registry. It is called when the registry becomes the active one.
+func (r *Registry) Activate() {
+	config.JiraClientPoolReceivers.Set(float64(len(r.clients)))
+	config.JiraClientPoolTransports.Set(float64(len(r.transports)))
+}
+
+// CloseIdleConnections closes the idle connections of all transports. Connections in use by in-flight requests are
+// not affected and are closed once they become idle, which makes it safe to call on a registry that was just replaced.
+func (r *Registry) CloseIdleConnections() {
+	for _, tr := range r.transports {
+		tr.CloseIdleConnections()
+	}
+}
+
+// authClient returns an HTTP client that authenticates as the receiver over tr: with its personal access token as
+// bearer token if one is configured, with basic auth of its email and API token or of its user and password otherwise.
+// The configuration ensures only one of them is set.
+func authClient(rc *config.ReceiverConfig, tr http.RoundTripper) *http.Client {
+	if rc.PersonalAccessToken != "" {
+		auth := &jira.PATAuthTransport{
+			Token:     string(rc.PersonalAccessToken),
+			Transport: tr,
+		}
+		return auth.Client()
+	}
+	if rc.Email != "" {
+		auth := &jira.BasicAuthTransport{
+			Username:  rc.Email,
+			Password:  string(rc.APIToken),
+			Transport: tr,
+		}
+		return auth.Client()
+	}
+	auth := &jira.BasicAuthTransport{
+		Username:  rc.User,
+		Password:  string(rc.Password),
+		Transport: tr,
+	}
+	return auth.Client()
+}
+
+// apiV3Transport sends the requests go-jira builds for version 2 of the REST API to the same resource of version 3.
+// Comments and transitions stay on version 2: go-jira sends the body of comments, also of the one that goes with a
+// transition, as plain text, which version 3 rejects.
+type apiV3Transport struct {
+	next http.RoundTripper
+}
+
+func (t apiV3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	const v2, v3 = "/rest/api/2/", "/rest/api/3/"
+	if i := strings.Index(req.URL.Path, v2); i >= 0 && !strings.HasSuffix(req.URL.Path, "/comment") &&
+		!strings.HasSuffix(req.URL.Path, "/transitions") {
+		// A RoundTripper must not modify the request it is given.
+		req = req.Clone(req.Context())
+		req.URL.Path = req.URL.Path[:i] + v3 + req.URL.Path[i+len(v2):]
+		req.URL.RawPath = strings.Replace(req.URL.RawPath, v2, v3, 1)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// apiHost returns the scheme and host of the Jira instance a receiver talks to.
+func apiHost(rc *config.ReceiverConfig) (string, error) {
+	u, err := url.Parse(rc.APIURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse api_url %q", rc.APIURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// transportKey identifies the connection pool a receiver uses: receivers share one if they talk to the same Jira
+// instance with the same TLS and HTTP settings.
+func transportKey(host string, rc *config.ReceiverConfig) string {
+	key := host
+	if tc := rc.TLSConfig; tc != nil {
+		key += fmt.Sprintf(" ca=%q cert=%q key=%q server_name=%q insecure=%t",
+			tc.CAFile, tc.CertFile, tc.KeyFile, tc.ServerName, tc.InsecureSkipVerify)
+	}
+	if hc := rc.HTTPConfig; hc != nil {
+		key += fmt.Sprintf(" proxy=%q no_proxy=%q dial=%d tls_handshake=%d response_header=%d max_idle=%d",
+			hc.ProxyURL, hc.NoProxy, hc.DialTimeout, hc.TLSHandshakeTimeout, hc.ResponseHeaderTimeout, hc.MaxIdleConnsPerHost)
+	}
+	return key
+}
+
+// NewTLSConfig builds the client TLS configuration described by cfg. Files are read when it is called, that is on
+// every configuration (re)load. A nil cfg yields the defaults: server certificates are validated against the system
+// roots.
+func NewTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg == nil {
+		return tlsConfig, nil
+	}
+	tlsConfig.ServerName = cfg.ServerName
+	tlsConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca_file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no PEM certificates found in ca_file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newTransport builds the transport for host. Settings left unset in httpConfig, or all of them if it is nil, keep
+// their defaults; requests then go through the proxy given by the environment.
+func newTransport(host string, tlsConfig *tls.Config, httpConfig *config.HTTPConfig) *http.Transport {
+	hc := config.HTTPConfig{}
+	if httpConfig != nil {
+		hc = *httpConfig
+	}
+	dialer := &net.Dialer{
+		Timeout:   durationOr(hc.DialTimeout, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	maxIdleConnsPerHost := hc.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	return &http.Transport{
+		Proxy:                 proxyFunc(hc),
+		DialContext:           countingDialContext(host, dialer.DialContext),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   durationOr(hc.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Duration(hc.ResponseHeaderTimeout),
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+}
+
+// proxyFunc returns the proxy selection of the transport: proxy_url for every host not matched by no_proxy if it is
+// set, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables otherwise.
+func proxyFunc(hc config.HTTPConfig) func(*http.Request) (*url.URL, error) {
+	if hc.ProxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  hc.ProxyURL,
+		HTTPSProxy: hc.ProxyURL,
+		NoProxy:    hc.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+func durationOr(d config.Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// countingDialContext wraps dial so that every new connection and its lifetime is reflected in the connection metrics.
+func countingDialContext(host string, dial dialContextFunc) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		config.JiraConnectionsOpened.WithLabelValues(host).Inc()
+		config.JiraConnectionsOpen.WithLabelValues(host).Inc()
+		return &countedConn{Conn: conn, host: host}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	host string
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { config.JiraConnectionsOpen.WithLabelValues(c.host).Dec() })
+	return c.Conn.Close()
+}
@@ -0,0 +1,359 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiraclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/andygrunwald/go-jira"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_SharesConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issues": []}`))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	host := u.Scheme + "://" + u.Host
+
+	conf := &config.Config{Receivers: []*config.ReceiverConfig{
+		{Name: "a", APIURL: srv.URL, User: "a", Password: "a"},
+		{Name: "b", APIURL: srv.URL + "/", User: "b", Password: "b"},
+		{Name: "c", APIURL: "https://other.example.com", User: "c", Password: "c"},
+	}}
+	r, err := NewRegistry(conf)
+	require.NoError(t, err)
+	defer r.CloseIdleConnections()
+
+	r.Activate()
+	require.Equal(t, float64(3), testutil.ToFloat64(config.JiraClientPoolReceivers))
+	require.Equal(t, float64(2), testutil.ToFloat64(config.JiraClientPoolTransports))
+
+	_, ok := r.Client("missing")
+	require.False(t, ok)
+
+	for i := 0; i < 5; i++ {
+		for _, name := range []string{"a", "b"} {
+			c, ok := r.Client(name)
+			require.True(t, ok)
+			_, _, err := c.Issue.Search("project=X", nil)
+			require.NoError(t, err)
+		}
+	}
+	// Sequential requests from both receivers reuse a single keep-alive connection.
+	require.Equal(t, float64(1), testutil.ToFloat64(config.JiraConnectionsOpened.WithLabelValues(host)))
+	require.Equal(t, float64(1), testutil.ToFloat64(config.JiraConnectionsOpen.WithLabelValues(host)))
+
+	r.CloseIdleConnections()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(config.JiraConnectionsOpen.WithLabelValues(host)) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestRegistry_Auth(t *testing.T) {
+	authorization := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"issues": []}`))
+	}))
+	defer srv.Close()
+
+	for _, tcase := range []struct {
+		receiver *config.ReceiverConfig
+		expected string
+	}{
+		{
+			receiver: &config.ReceiverConfig{Name: "basic", APIURL: srv.URL, User: "jiralert", Password: "secret"},
+			expected: "Basic " + base64.StdEncoding.EncodeToString([]byte("jiralert:secret")),
+		},
+		{
+			receiver: &config.ReceiverConfig{Name: "pat", APIURL: srv.URL, PersonalAccessToken: "token"},
+			expected: "Bearer token",
+		},
+		{
+			receiver: &config.ReceiverConfig{Name: "cloud", APIURL: srv.URL, Email: "jiralert@example.com", APIToken: "token"},
+			expected: "Basic " + base64.StdEncoding.EncodeToString([]byte("jiralert@example.com:token")),
+		},
+	} {
+		t.Run(tcase.receiver.Name, func(t *testing.T) {
+			r, err := NewRegistry(&config.Config{Receivers: []*config.ReceiverConfig{tcase.receiver}})
+			require.NoError(t, err)
+			defer r.CloseIdleConnections()
+
+			c, ok := r.Client(tcase.receiver.Name)
+			require.True(t, ok)
+			_, _, err = c.Issue.Search("project=X", nil)
+			require.NoError(t, err)
+			require.Equal(t, tcase.expected, <-authorization)
+		})
+	}
+}
+
+func TestRegistry_APIVersion(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		_, _ = w.Write([]byte(`{"issues": []}`))
+	}))
+	defer srv.Close()
+
+	r, err := NewRegistry(&config.Config{Receivers: []*config.ReceiverConfig{
+		{Name: "v2", APIURL: srv.URL + "/jira", User: "a", Password: "a", APIVersion: 2},
+		{Name: "v3", APIURL: srv.URL + "/jira", User: "a", Password: "a", APIVersion: 3},
+	}})
+	require.NoError(t, err)
+	defer r.CloseIdleConnections()
+
+	for name, expected := range map[string]string{"v2": "/jira/rest/api/2/search", "v3": "/jira/rest/api/3/search"} {
+		c, _ := r.Client(name)
+		_, _, err = c.Issue.Search("project=X", nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, <-paths)
+	}
+	// Comments are plain text, which only version 2 accepts.
+	c, _ := r.Client("v3")
+	_, _, err = c.Issue.AddComment("ABC-1", &jira.Comment{Body: "a"})
+	require.NoError(t, err)
+	require.Equal(t, "/jira/rest/api/2/issue/ABC-1/comment", <-paths)
+	// So is the comment that goes with a transition, e.g. the auto_resolve one.
+	_, err = c.Issue.DoTransitionWithPayload("ABC-1", jira.CreateTransitionPayload{
+		Transition: jira.TransitionPayload{ID: "1"},
+		Update: jira.TransitionPayloadUpdate{Comment: []jira.TransitionPayloadComment{{
+			Add: jira.TransitionPayloadCommentBody{Body: "a"},
+		}}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/jira/rest/api/2/issue/ABC-1/transitions", <-paths)
+	// Both versions share the connection pool.
+	r.Activate()
+	require.Equal(t, float64(1), testutil.ToFloat64(config.JiraClientPoolTransports))
+}
+
+// writeClientCert writes a self-signed client certificate and its key into dir.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "jiralert"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile, cert
+}
+
+func TestRegistry_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	newServer := func(clientAuth tls.ClientAuthType) *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"issues": []}`))
+		}))
+		srv.TLS = &tls.Config{ClientAuth: clientAuth, ClientCAs: clientCAs}
+		srv.StartTLS()
+		return srv
+	}
+	// All httptest servers share the same certificate.
+	srv := newServer(tls.NoClientCert)
+	srv.Close()
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+
+	for _, tcase := range []struct {
+		name       string
+		tlsConfig  *config.TLSConfig
+		clientAuth tls.ClientAuthType
+		err        string
+	}{
+		{name: "system roots", err: "certificate signed by unknown authority"},
+		{name: "insecure", tlsConfig: &config.TLSConfig{InsecureSkipVerify: true}},
+		{name: "ca file", tlsConfig: &config.TLSConfig{CAFile: caFile}},
+		// The httptest certificate is valid for example.com, besides the loopback addresses.
+		{name: "server name", tlsConfig: &config.TLSConfig{CAFile: caFile, ServerName: "example.com"}},
+		{name: "wrong server name", tlsConfig: &config.TLSConfig{CAFile: caFile, ServerName: "jira.example.org"}, err: "not jira.example.org"},
+		{
+			name: "client certificate", tlsConfig: &config.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+			clientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{name: "missing client certificate", tlsConfig: &config.TLSConfig{CAFile: caFile}, clientAuth: tls.RequireAndVerifyClientCert, err: "certificate required"},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			srv := newServer(tcase.clientAuth)
+			defer srv.Close()
+			r, err := NewRegistry(&config.Config{Receivers: []*config.ReceiverConfig{
+				{Name: "a", APIURL: srv.URL, User: "a", Password: "a", TLSConfig: tcase.tlsConfig},
+			}})
+			require.NoError(t, err)
+			defer r.CloseIdleConnections()
+
+			c, _ := r.Client("a")
+			_, _, err = c.Issue.Search("project=X", nil)
+			if tcase.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tcase.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewTLSConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	for _, tcase := range []struct {
+		tlsConfig *config.TLSConfig
+		err       string
+	}{
+		{tlsConfig: &config.TLSConfig{CAFile: filepath.Join(dir, "missing.crt")}, err: "read ca_file"},
+		{tlsConfig: &config.TLSConfig{CAFile: notPEM}, err: "no PEM certificates found"},
+		{tlsConfig: &config.TLSConfig{CertFile: notPEM, KeyFile: notPEM}, err: "load client certificate"},
+	} {
+		_, err := NewTLSConfig(tcase.tlsConfig)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tcase.err)
+
+		_, err = NewRegistry(&config.Config{Receivers: []*config.ReceiverConfig{
+			{Name: "a", APIURL: "https://jira.example.com", TLSConfig: tcase.tlsConfig},
+		}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `invalid tls_config in receiver "a"`)
+	}
+}
+
+func TestRegistry_HTTPConfig(t *testing.T) {
+	// The forward proxy stand-in answers every request itself, on behalf of a Jira host that does not resolve.
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		_, _ = w.Write([]byte(`{"issues": []}`))
+	}))
+	defer proxy.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"issues": []}`))
+	}))
+	defer slow.Close()
+
+	for _, tcase := range []struct {
+		name       string
+		apiURL     string
+		httpConfig *config.HTTPConfig
+		proxied    string
+		err        string
+	}{
+		{
+			name:       "proxy",
+			apiURL:     "http://jira.example.invalid",
+			httpConfig: &config.HTTPConfig{ProxyURL: proxy.URL},
+			proxied:    "http://jira.example.invalid/rest/api/2/search?jql=project%3DX",
+		},
+		{
+			name:       "response header timeout",
+			apiURL:     slow.URL,
+			httpConfig: &config.HTTPConfig{ResponseHeaderTimeout: config.Duration(50 * time.Millisecond)},
+			err:        "timeout awaiting response headers",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			r, err := NewRegistry(&config.Config{Receivers: []*config.ReceiverConfig{
+				{Name: "a", APIURL: tcase.apiURL, User: "a", Password: "a", HTTPConfig: tcase.httpConfig},
+			}})
+			require.NoError(t, err)
+			defer r.CloseIdleConnections()
+
+			c, _ := r.Client("a")
+			_, _, err = c.Issue.Search("project=X", nil)
+			if tcase.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tcase.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tcase.proxied, <-proxied)
+		})
+	}
+}
+
+func TestNewTransport_HTTPConfig(t *testing.T) {
+	hc := &config.HTTPConfig{
+		ProxyURL:            "http://proxy.example.com:3128",
+		NoProxy:             "internal.example.com,10.0.0.0/8",
+		TLSHandshakeTimeout: config.Duration(3 * time.Second),
+		MaxIdleConnsPerHost: 5,
+	}
+	tr := newTransport("https://jira.example.com", nil, hc)
+	require.Equal(t, 3*time.Second, tr.TLSHandshakeTimeout)
+	require.Equal(t, 5, tr.MaxIdleConnsPerHost)
+	require.Equal(t, time.Duration(0), tr.ResponseHeaderTimeout)
+
+	for _, tcase := range []struct {
+		target string
+		proxy  string
+	}{
+		{target: "https://jira.example.com/rest/api/2/search", proxy: "http://proxy.example.com:3128"},
+		{target: "http://jira.example.com/rest/api/2/search", proxy: "http://proxy.example.com:3128"},
+		{target: "https://jira.internal.example.com/rest/api/2/search"},
+		{target: "https://10.1.2.3/rest/api/2/search"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, tcase.target, nil)
+		require.NoError(t, err)
+		u, err := tr.Proxy(req)
+		require.NoError(t, err)
+		if tcase.proxy == "" {
+			require.Nil(t, u, tcase.target)
+			continue
+		}
+		require.Equal(t, tcase.proxy, u.String(), tcase.target)
+	}
+
+	tr = newTransport("https://jira.example.com", nil, nil)
+	require.Equal(t, defaultTLSHandshakeTimeout, tr.TLSHandshakeTimeout)
+	require.Equal(t, defaultMaxIdleConnsPerHost, tr.MaxIdleConnsPerHost)
+}
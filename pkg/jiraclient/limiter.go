@@ -0,0 +1,190 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiraclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+)
+
+var (
+	limitersMtx sync.Mutex
+	// limiters holds the rate limiter of each Jira instance by host. They outlive registries, so that reloading the
+	// configuration does not refill the buckets.
+	limiters = map[string]*tokenBucket{}
+)
+
+// limiterFor returns the rate limiter of host, set to the limit of rl.
+func limiterFor(host string, rl *config.RateLimitConfig) *tokenBucket {
+	limitersMtx.Lock()
+	defer limitersMtx.Unlock()
+	b, ok := limiters[host]
+	if !ok {
+		b = newTokenBucket(rl.Rate, rl.Burst, time.Now())
+		limiters[host] = b
+		return b
+	}
+	b.setLimit(rl.Rate, rl.Burst, time.Now())
+	return b
+}
+
+// tokenBucket is a token bucket rate limiter. Requests reserve tokens ahead of time, so that the bucket can go into
+// debt: its tokens are then negative and the next reservation waits for the debt to be paid off first.
+type tokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// advance adds the tokens accumulated since the last call. b.mtx must be held.
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) setLimit(rate float64, burst int, now time.Time) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.advance(now)
+	b.rate, b.burst = rate, float64(burst)
+	b.tokens = math.Min(b.burst, b.tokens)
+}
+
+// reserve takes a token and returns how long to wait until it is due. If that is longer than maxWait, no token is
+// taken and ok is false. A negative maxWait means no bound.
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (wait time.Duration, ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.advance(now)
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if maxWait >= 0 && wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// cancel returns a reserved token that was not used.
+func (b *tokenBucket) cancel() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// errRateLimited is returned by wait if the request's deadline would pass before it is its turn.
+type errRateLimited struct {
+	wait time.Duration
+}
+
+func (e errRateLimited) Error() string {
+	return fmt.Sprintf("rate limited for %s", e.wait)
+}
+
+// wait blocks until the caller may send a request or ctx is done. A request whose turn would only come after the
+// deadline of ctx is rejected at once with errRateLimited.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	now := time.Now()
+	maxWait := time.Duration(-1)
+	if deadline, ok := ctx.Deadline(); ok {
+		if maxWait = deadline.Sub(now); maxWait < 0 {
+			maxWait = 0
+		}
+	}
+	d, ok := b.reserve(now, maxWait)
+	if !ok {
+		return errRateLimited{wait: d}
+	}
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimitTransport sends requests once the rate limiter of their Jira instance lets them. Requests that it rejects
+// are answered with 429 Too Many Requests and a Retry-After header, as if Jira had throttled them, so that callers
+// treat them as retryable.
+type rateLimitTransport struct {
+	host    string
+	limiter *tokenBucket
+	next    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	err := t.limiter.wait(req.Context())
+	if rl, ok := err.(errRateLimited); ok {
+		config.JiraRateLimitRejected.WithLabelValues(t.host).Inc()
+		return tooManyRequests(req, rl.wait), nil
+	}
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	config.JiraRateLimitWaitSeconds.WithLabelValues(t.host).Observe(time.Since(start).Seconds())
+	return t.next.RoundTrip(req)
+}
+
+// tooManyRequests returns the response to req telling to retry after wait.
+func tooManyRequests(req *http.Request, wait time.Duration) *http.Response {
+	closeBody(req)
+	body := fmt.Sprintf(`{"errorMessages":["jiralert rate limit for %s exceeded"]}`, req.URL.Host)
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests)),
+		StatusCode: http.StatusTooManyRequests,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+			"Retry-After":  {strconv.Itoa(int(math.Ceil(wait.Seconds())))},
+		},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// closeBody closes the body of a request that is not sent, as a RoundTripper must.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
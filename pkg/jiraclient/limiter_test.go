@@ -0,0 +1,99 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiraclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 2, now)
+
+	// The burst is available at once, further requests are spaced by 1/rate.
+	for _, expected := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		wait, ok := b.reserve(now, -1)
+		require.True(t, ok)
+		require.Equal(t, expected, wait)
+	}
+	// Requests that would wait longer than allowed take no token.
+	wait, ok := b.reserve(now, time.Second)
+	require.False(t, ok)
+	require.Equal(t, 1500*time.Millisecond, wait)
+	wait, ok = b.reserve(now.Add(2*time.Second), 0)
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), wait)
+
+	// Idle time refills up to the burst only.
+	now = now.Add(time.Hour)
+	b.setLimit(1, 1, now)
+	_, ok = b.reserve(now, 0)
+	require.True(t, ok)
+	wait, ok = b.reserve(now, 0)
+	require.False(t, ok)
+	require.Equal(t, time.Second, wait)
+}
+
+func TestRegistry_RateLimit(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"issues": []}`))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	host := u.Scheme + "://" + u.Host
+
+	rl := &config.RateLimitConfig{Rate: 10, Burst: 1}
+	conf := &config.Config{Receivers: []*config.ReceiverConfig{
+		{Name: "a", APIURL: srv.URL, User: "a", Password: "a", RateLimit: rl},
+		{Name: "b", APIURL: srv.URL, Email: "b@example.com", APIToken: "b", RateLimit: rl},
+	}}
+	r, err := NewRegistry(conf)
+	require.NoError(t, err)
+	defer r.CloseIdleConnections()
+	a, _ := r.Client("a")
+	b, _ := r.Client("b")
+
+	// Both receivers draw from the same bucket: the second request waits for a token.
+	start := time.Now()
+	_, _, err = a.Issue.Search("project=X", nil)
+	require.NoError(t, err)
+	_, _, err = b.Issue.Search("project=X", nil)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	require.Equal(t, 1, testutil.CollectAndCount(config.JiraRateLimitWaitSeconds))
+
+	// A request whose turn comes after its deadline is answered with 429 right away, without reaching Jira.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := a.NewRequestWithContext(ctx, http.MethodGet, "rest/api/2/search", nil)
+	require.NoError(t, err)
+	resp, err := a.Do(req, nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+	require.Equal(t, int32(2), requests.Load())
+	require.Equal(t, float64(1), testutil.ToFloat64(config.JiraRateLimitRejected.WithLabelValues(host)))
+}
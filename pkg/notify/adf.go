@@ -0,0 +1,165 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"regexp"
+	"strings"
+)
+
+// adfDoc is the root of a document in the Atlassian Document Format, which version 3 of the Jira REST API takes for
+// rich text fields such as the description.
+type adfDoc struct {
+	Type    string     `json:"type"`
+	Version int        `json:"version"`
+	Content []*adfNode `json:"content"`
+}
+
+type adfNode struct {
+	Type    string                 `json:"type"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Content []*adfNode             `json:"content,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Marks   []*adfNode             `json:"marks,omitempty"`
+}
+
+var (
+	// adfBullet matches the items of bullet lists: "- item", "* item" or "• item", possibly indented.
+	adfBullet = regexp.MustCompile(`^\s*[-*•]\s+(.*)$`)
+	// adfLink matches Jira wiki links, [text|url] or [url], and bare URLs.
+	adfLink = regexp.MustCompile(`\[([^\[\]|]*)\|(https?://[^\s\]]+)\]|\[(https?://[^\s\]]+)\]|https?://[^\s<>"]+`)
+)
+
+// toADF converts a rendered description to the Atlassian Document Format. Code blocks are enclosed in ``` or in
+// {code}/{noformat}, list items start with "-" or "*", and blank lines separate paragraphs. Bare URLs and Jira wiki
+// links become links; any other markup is kept as text.
+func toADF(text string) *adfDoc {
+	doc := &adfDoc{Type: "doc", Version: 1, Content: []*adfNode{}}
+	var paragraph, items []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			doc.Content = append(doc.Content, adfParagraph(paragraph))
+			paragraph = nil
+		}
+		if len(items) > 0 {
+			list := &adfNode{Type: "bulletList"}
+			for _, item := range items {
+				list.Content = append(list.Content, &adfNode{Type: "listItem", Content: []*adfNode{adfParagraph([]string{item})}})
+			}
+			doc.Content = append(doc.Content, list)
+			items = nil
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		if end, language, ok := adfCodeFence(line); ok {
+			flush()
+			var code []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != end; i++ {
+				code = append(code, lines[i])
+			}
+			block := &adfNode{Type: "codeBlock"}
+			if language != "" {
+				block.Attrs = map[string]interface{}{"language": language}
+			}
+			if c := strings.Join(code, "\n"); c != "" {
+				block.Content = []*adfNode{{Type: "text", Text: c}}
+			}
+			doc.Content = append(doc.Content, block)
+			continue
+		}
+		switch m := adfBullet.FindStringSubmatch(line); {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case m != nil:
+			if len(paragraph) > 0 {
+				flush()
+			}
+			items = append(items, m[1])
+		default:
+			if len(items) > 0 {
+				flush()
+			}
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+	return doc
+}
+
+// adfCodeFence reports whether line opens a code block, and returns the line closing it and its language.
+func adfCodeFence(line string) (end, language string, ok bool) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "```"):
+		return "```", strings.TrimSpace(strings.TrimPrefix(line, "```")), true
+	case line == "{noformat}":
+		return line, "", true
+	case line == "{code}":
+		return line, "", true
+	case strings.HasPrefix(line, "{code:") && strings.HasSuffix(line, "}"):
+		return "{code}", strings.TrimSuffix(strings.TrimPrefix(line, "{code:"), "}"), true
+	}
+	return "", "", false
+}
+
+// adfParagraph returns a paragraph of the given lines, separated by hard breaks.
+func adfParagraph(lines []string) *adfNode {
+	p := &adfNode{Type: "paragraph"}
+	for i, line := range lines {
+		if i > 0 {
+			p.Content = append(p.Content, &adfNode{Type: "hardBreak"})
+		}
+		p.Content = append(p.Content, adfInline(line)...)
+	}
+	return p
+}
+
+// adfInline returns the text nodes of line, with links marked as such.
+func adfInline(line string) []*adfNode {
+	var nodes []*adfNode
+	text := func(s string) {
+		if s != "" {
+			nodes = append(nodes, &adfNode{Type: "text", Text: s})
+		}
+	}
+	last := 0
+	for _, m := range adfLink.FindAllStringSubmatchIndex(line, -1) {
+		text(line[last:m[0]])
+		last = m[1]
+		// Punctuation following a bare URL most likely ends the sentence.
+		href := strings.TrimRight(line[m[0]:m[1]], ".,;:!?)")
+		label := href
+		switch {
+		case m[4] >= 0:
+			label, href = line[m[2]:m[3]], line[m[4]:m[5]]
+			if label == "" {
+				label = href
+			}
+		case m[6] >= 0:
+			label, href = line[m[6]:m[7]], line[m[6]:m[7]]
+		default:
+			last = m[0] + len(href)
+		}
+		nodes = append(nodes, &adfNode{
+			Type:  "text",
+			Text:  label,
+			Marks: []*adfNode{{Type: "link", Attrs: map[string]interface{}{"href": href}}},
+		})
+	}
+	text(line[last:])
+	return nodes
+}
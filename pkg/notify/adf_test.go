@@ -0,0 +1,105 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToADF(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{name: "empty", text: "", expected: `[]`},
+		{
+			name: "paragraphs",
+			text: "first line\nsecond line\n\n\nnext paragraph\n",
+			expected: `[
+				{"type":"paragraph","content":[{"type":"text","text":"first line"},{"type":"hardBreak"},{"type":"text","text":"second line"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"next paragraph"}]}
+			]`,
+		},
+		{
+			name: "bullet list",
+			text: "Labels:\n - alertname = A\n * team = sre\nSource: none",
+			expected: `[
+				{"type":"paragraph","content":[{"type":"text","text":"Labels:"}]},
+				{"type":"bulletList","content":[
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"alertname = A"}]}]},
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"team = sre"}]}]}
+				]},
+				{"type":"paragraph","content":[{"type":"text","text":"Source: none"}]}
+			]`,
+		},
+		{
+			name: "code blocks",
+			text: "```yaml\na: b\n\n  c: d\n```\n{code:go}\nx := 1\n{code}\n{noformat}\n- not a list\n{noformat}",
+			expected: `[
+				{"type":"codeBlock","attrs":{"language":"yaml"},"content":[{"type":"text","text":"a: b\n\n  c: d"}]},
+				{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"x := 1"}]},
+				{"type":"codeBlock","content":[{"type":"text","text":"- not a list"}]}
+			]`,
+		},
+		{
+			name: "links",
+			text: "See http://prometheus:9090/graph?g0.expr=up. Or [the runbook|https://runbooks.example.com/A], [https://example.com]",
+			expected: `[{"type":"paragraph","content":[
+				{"type":"text","text":"See "},
+				{"type":"text","text":"http://prometheus:9090/graph?g0.expr=up","marks":[{"type":"link","attrs":{"href":"http://prometheus:9090/graph?g0.expr=up"}}]},
+				{"type":"text","text":". Or "},
+				{"type":"text","text":"the runbook","marks":[{"type":"link","attrs":{"href":"https://runbooks.example.com/A"}}]},
+				{"type":"text","text":", "},
+				{"type":"text","text":"https://example.com","marks":[{"type":"link","attrs":{"href":"https://example.com"}}]}
+			]}]`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			b, err := json.Marshal(toADF(tcase.text))
+			require.NoError(t, err)
+			require.JSONEq(t, `{"type":"doc","version":1,"content":`+tcase.expected+`}`, string(b))
+		})
+	}
+}
+
+func TestNotify_APIVersion3(t *testing.T) {
+	conf := testReceiverConfig2()
+	conf.APIVersion = 3
+	data := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	fakeJira := newTestFakeJira()
+	receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+	_, err := receiver.Notify(context.Background(), data, true)
+	require.NoError(t, err)
+	issue := fakeJira.issuesByKey["1"]
+	require.Empty(t, issue.Fields.Description)
+	require.Equal(t, toADF("1"), issue.Fields.Unknowns["description"])
+
+	// The description is sent as a document on updates too.
+	data.Alerts = append(data.Alerts, alertmanager.Alert{Status: alertmanager.AlertFiring})
+	_, err = receiver.Notify(context.Background(), data, true)
+	require.NoError(t, err)
+	require.Equal(t, toADF("2"), issue.Fields.Unknowns["description"])
+	require.Empty(t, issue.Fields.Description)
+}
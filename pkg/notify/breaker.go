@@ -0,0 +1,193 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned, wrapped, for Jira requests that were not sent because the circuit breaker of the Jira
+// instance is open. It is worth a retry.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerPolicy configures the circuit breaker of a Jira instance.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the circuit.
+	FailureThreshold int
+	// OpenDuration is how long requests fail at once before a single probe request is let through.
+	OpenDuration time.Duration
+}
+
+// breakerPolicy returns the BreakerPolicy that c configures, or that of config.DefaultCircuitBreaker if c is nil.
+func breakerPolicy(c *config.CircuitBreakerConfig) BreakerPolicy {
+	if c == nil {
+		c = &config.DefaultCircuitBreaker
+	}
+	return BreakerPolicy{FailureThreshold: c.FailureThreshold, OpenDuration: time.Duration(c.OpenDuration)}
+}
+
+type circuitState int
+
+// The values of the states are those of the jiralert_jira_circuit_state metric.
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+var (
+	breakersMtx sync.Mutex
+	// breakers holds the circuit breaker of each Jira instance by scheme and host. Receivers are created per
+	// notification, so the breakers are kept here for the life of the process.
+	breakers = map[string]*circuitBreaker{}
+)
+
+// breakerFor returns the circuit breaker of the Jira instance of apiURL, following policy, which changes with the
+// configuration.
+func breakerFor(apiURL string, policy BreakerPolicy) *circuitBreaker {
+	instance := apiURL
+	if u, err := url.Parse(apiURL); err == nil {
+		instance = u.Scheme + "://" + u.Host
+	}
+	breakersMtx.Lock()
+	defer breakersMtx.Unlock()
+	b, ok := breakers[instance]
+	if !ok {
+		b = newCircuitBreaker(instance, policy)
+		breakers[instance] = b
+		return b
+	}
+	b.mtx.Lock()
+	b.policy = policy
+	b.mtx.Unlock()
+	return b
+}
+
+// CircuitStates returns the state of the circuit breaker of every Jira instance that was talked to: closed, open or
+// half-open.
+func CircuitStates() map[string]string {
+	breakersMtx.Lock()
+	defer breakersMtx.Unlock()
+	states := make(map[string]string, len(breakers))
+	for instance, b := range breakers {
+		b.mtx.Lock()
+		states[instance] = b.state.String()
+		b.mtx.Unlock()
+	}
+	return states
+}
+
+// circuitBreaker stops sending requests to a Jira instance that keeps failing, so that notifications fail fast instead
+// of each waiting out its timeout. Once open for the policy's OpenDuration, it is half-open: a single probe request is
+// let through, closing the circuit if it succeeds and opening it again if it fails.
+type circuitBreaker struct {
+	instance string
+	policy   BreakerPolicy
+	timeNow  func() time.Time
+
+	mtx      sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(instance string, policy BreakerPolicy) *circuitBreaker {
+	config.JiraCircuitState.WithLabelValues(instance).Set(float64(circuitClosed))
+	return &circuitBreaker{instance: instance, policy: policy, timeNow: time.Now}
+}
+
+// allow tells whether a request may be sent. A request allowed while half-open is the probe, whose outcome must be
+// passed to record.
+func (b *circuitBreaker) allow() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if b.timeNow().Sub(b.openedAt) < b.policy.OpenDuration {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record counts the outcome of an allowed request.
+func (b *circuitBreaker) record(failed bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if !failed {
+		b.failures = 0
+		b.probing = false
+		if b.state != circuitClosed {
+			log.Infof("Jira instance recovered, closing circuit instance:%s", b.instance)
+			b.setState(circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.policy.FailureThreshold {
+		if b.state != circuitOpen {
+			log.Warnf("opening circuit after %d consecutive failures instance:%s", b.failures, b.instance)
+		}
+		b.probing = false
+		b.openedAt = b.timeNow()
+		b.setState(circuitOpen)
+	}
+}
+
+// cancel tells that an allowed request was cancelled by the caller. It says nothing about the Jira instance, so only a
+// probe is given back for another request to take.
+func (b *circuitBreaker) cancel() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.probing = false
+}
+
+// setState changes the state. b.mtx must be held.
+func (b *circuitBreaker) setState(s circuitState) {
+	b.state = s
+	config.JiraCircuitState.WithLabelValues(b.instance).Set(float64(s))
+}
+
+// isInstanceFailure tells whether a failed request counts against the Jira instance: it got no response, or a server
+// error. Client errors and throttling show that the instance is up.
+func isInstanceFailure(resp *jira.Response) bool {
+	return resp == nil || resp.Response == nil || resp.StatusCode >= 500
+}
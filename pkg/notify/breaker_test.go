@@ -0,0 +1,133 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker("https://breaker.example.com", BreakerPolicy{FailureThreshold: 3, OpenDuration: time.Minute})
+	b.timeNow = func() time.Time { return now }
+	state := func() float64 {
+		return testutil.ToFloat64(config.JiraCircuitState.WithLabelValues("https://breaker.example.com"))
+	}
+
+	// Successes reset the count of consecutive failures.
+	for _, failed := range []bool{true, true, false, true, true} {
+		require.True(t, b.allow())
+		b.record(failed)
+	}
+	require.Equal(t, float64(circuitClosed), state())
+	require.True(t, b.allow())
+	b.record(true)
+	require.Equal(t, float64(circuitOpen), state())
+	require.False(t, b.allow())
+
+	// Once half-open, a single probe is let through; its failure opens the circuit again.
+	now = now.Add(time.Minute)
+	require.True(t, b.allow())
+	require.Equal(t, float64(circuitHalfOpen), state())
+	require.False(t, b.allow())
+	b.record(true)
+	require.Equal(t, float64(circuitOpen), state())
+	require.False(t, b.allow())
+
+	// A successful probe closes it.
+	now = now.Add(time.Minute)
+	require.True(t, b.allow())
+	b.record(false)
+	require.Equal(t, float64(circuitClosed), state())
+	require.True(t, b.allow())
+	require.True(t, b.allow())
+}
+
+func TestNotify_CircuitBreaker(t *testing.T) {
+	data := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	fakeJira := newTestFakeJira()
+	fakeJira.failures["Issue.Search"] = []*jira.Response{
+		testErrResponse(http.StatusBadGateway, ""),
+		testErrResponse(http.StatusBadGateway, ""),
+	}
+	conf := testReceiverConfig1()
+	conf.APIURL = "https://down.example.com/jira"
+	conf.CircuitBreaker = &config.CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: config.Duration(time.Minute)}
+	receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+	receiver.retry = RetryPolicy{MaxAttempts: 1}
+	now := time.Now()
+	receiver.breaker.timeNow = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := receiver.Notify(context.Background(), data, true)
+		require.Error(t, err)
+		require.True(t, IsRetryable(err))
+	}
+	require.Equal(t, map[string]string{"https://down.example.com": "open"}, filterStates(CircuitStates(), "https://down.example.com"))
+
+	// While open, notifications fail at once without calling Jira.
+	_, err := receiver.Notify(context.Background(), data, true)
+	require.True(t, errors.Is(err, ErrCircuitOpen), "%v", err)
+	require.True(t, IsRetryable(err))
+	require.Equal(t, 2, fakeJira.calls["Issue.Search"])
+
+	// Receivers of the same instance share the breaker; the probe sent once it is half-open closes it.
+	now = now.Add(time.Minute)
+	_, err = NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex()).Notify(context.Background(), data, true)
+	require.NoError(t, err)
+	require.Len(t, fakeJira.issuesByKey, 1)
+	require.Equal(t, map[string]string{"https://down.example.com": "closed"}, filterStates(CircuitStates(), "https://down.example.com"))
+}
+
+func TestNewReceiver_NoBreaker(t *testing.T) {
+	// Previews and dry runs do not talk to Jira, or only read, so they leave the breakers alone.
+	conf := testReceiverConfig1()
+	conf.APIURL = "https://preview.example.com"
+	require.Nil(t, NewReceiver(conf, template.SimpleTemplate(), nil, NewKeyedMutex()).breaker)
+	conf.DryRun = true
+	receiver := NewReceiver(conf, template.SimpleTemplate(), newTestFakeJira(), NewKeyedMutex())
+	require.Nil(t, receiver.breaker)
+	_, err := receiver.Notify(context.Background(), &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}, true)
+	require.NoError(t, err)
+	require.Empty(t, filterStates(CircuitStates(), "https://preview.example.com"))
+}
+
+// filterStates returns the states of the given instances, leaving out those of other tests.
+func filterStates(states map[string]string, instances ...string) map[string]string {
+	filtered := map[string]string{}
+	for _, instance := range instances {
+		if state, ok := states[instance]; ok {
+			filtered[instance] = state
+		}
+	}
+	return filtered
+}
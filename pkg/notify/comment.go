@@ -0,0 +1,206 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// CommentData is the input of the comment template: the notification, and how the alert group changed since the
+// previous notification.
+type CommentData struct {
+	*alertmanager.Data
+	// NewlyFiring are the firing alerts that were not firing at the previous notification.
+	NewlyFiring alertmanager.Alerts
+	// NewlyResolved are the resolved alerts that were firing at the previous notification.
+	NewlyResolved alertmanager.Alerts
+	// Reopened is set if the issue was reopened for the notification.
+	Reopened bool
+}
+
+// newCommentData returns the comment template input for data, about the alerts that changed since the previous
+// notification, at which the alerts with the keys previous were firing. Nothing changed if previous is nil, that is
+// if the previous notification is unknown.
+func newCommentData(data *alertmanager.Data, previous []string, reopened bool) *CommentData {
+	cd := &CommentData{Data: data, Reopened: reopened}
+	if previous == nil {
+		return cd
+	}
+	wasFiring := make(map[string]bool, len(previous))
+	for _, k := range previous {
+		wasFiring[k] = true
+	}
+	for _, a := range data.Alerts {
+		switch a.Status {
+		case alertmanager.AlertResolved:
+			if wasFiring[alertKey(a)] {
+				cd.NewlyResolved = append(cd.NewlyResolved, a)
+			}
+		default:
+			if !wasFiring[alertKey(a)] {
+				cd.NewlyFiring = append(cd.NewlyFiring, a)
+			}
+		}
+	}
+	return cd
+}
+
+// alertsProperty is the issue property recording the alerts firing at the last notification of the alert group, for
+// the comment on the next one to tell what changed.
+const alertsProperty = "jiralert.alerts"
+
+// alertsRecord is the value of the alertsProperty issue property.
+type alertsRecord struct {
+	// Firing holds the keys of the firing alerts.
+	Firing []string `json:"firing"`
+}
+
+// alertKey identifies an alert within its group by a hash of its labels.
+func alertKey(a alertmanager.Alert) string {
+	h := sha256.New()
+	for _, p := range a.Labels.SortedPairs() {
+		fmt.Fprintf(h, "%q=%q,", p.Name, p.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// firingAlertKeys returns the sorted keys of the firing alerts of data.
+func firingAlertKeys(data *alertmanager.Data) []string {
+	keys := []string{}
+	for _, a := range data.Alerts {
+		if a.Status != alertmanager.AlertResolved {
+			keys = append(keys, alertKey(a))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// previousAlerts returns the keys of the alerts firing at the last notification recorded on the issue, or nil if
+// none was recorded.
+func (r *Receiver) previousAlerts(ctx context.Context, issueKey string) ([]string, bool, error) {
+	var raw json.RawMessage
+	retry, err := r.do(ctx, "Issue.GetProperty", func() (resp *jira.Response, err error) {
+		raw, resp, err = r.client.GetPropertyWithContext(ctx, issueKey, alertsProperty)
+		return resp, err
+	})
+	if err != nil {
+		log.Errorf("failed to get the alerts of the previous notification of issue key:%s err:%v", issueKey, err)
+		return nil, retry, err
+	}
+	if raw == nil {
+		return nil, false, nil
+	}
+	var rec alertsRecord
+	if err := json.Unmarshal(raw, &rec); err != nil || rec.Firing == nil {
+		log.Warnf("ignoring the malformed %s property of issue key:%s", alertsProperty, issueKey)
+		return nil, false, nil
+	}
+	return rec.Firing, false, nil
+}
+
+// recordAlerts records on the issue the keys of the alerts firing at this notification.
+func (r *Receiver) recordAlerts(ctx context.Context, issueKey string, firing []string) (bool, error) {
+	retry, err := r.do(ctx, "Issue.SetProperty", func() (*jira.Response, error) {
+		return r.client.SetPropertyWithContext(ctx, issueKey, alertsProperty, &alertsRecord{Firing: firing})
+	})
+	if err != nil {
+		log.Errorf("failed to record the alerts of issue key:%s err:%v", issueKey, err)
+		return retry, err
+	}
+	return false, nil
+}
+
+// ResolveCommentData is the input of the auto_resolve comment template.
+type ResolveCommentData struct {
+	*alertmanager.Data
+	// Duration is how long the alert group fired, to the second: from the start of its earliest alert until it is
+	// resolved. It is 0 if the notification has no alerts.
+	Duration time.Duration
+}
+
+// newResolveCommentData returns the auto_resolve comment template input for data, for an issue resolved at now.
+func newResolveCommentData(data *alertmanager.Data, now time.Time) *ResolveCommentData {
+	var start time.Time
+	for i, a := range data.Alerts {
+		if i == 0 || a.StartsAt.Before(start) {
+			start = a.StartsAt
+		}
+	}
+	if start.IsZero() {
+		return &ResolveCommentData{Data: data}
+	}
+	return &ResolveCommentData{Data: data, Duration: now.Sub(start).Round(time.Second)}
+}
+
+// comment posts the receiver's comment template to issue if alerts joined or left the group since the previous
+// notification, or if the issue was reopened, and records the firing alerts for the next notification. It does
+// nothing if the receiver has no comment template.
+func (r *Receiver) comment(ctx context.Context, issue *jira.Issue, data *alertmanager.Data, reopened bool, res *NotifyResult) (bool, error) {
+	if r.conf.Comment == "" {
+		return false, nil
+	}
+	previous, retry, err := r.previousAlerts(ctx, issue.Key)
+	if err != nil {
+		return retry, err
+	}
+	cd := newCommentData(data, previous, reopened)
+	if reopened || len(cd.NewlyFiring) > 0 || len(cd.NewlyResolved) > 0 {
+		body, err := r.tmpl.Execute(r.conf.Comment, cd)
+		if err != nil {
+			log.Errorf("failed to execute comment template: %v", err)
+			return false, errors.Wrap(err, "render issue comment")
+		}
+		log.Debug("msg", "adding comment", "key", issue.Key, "firing", len(cd.NewlyFiring), "resolved", len(cd.NewlyResolved))
+		retry, err := r.do(ctx, "Issue.AddComment", func() (resp *jira.Response, err error) {
+			_, resp, err = r.client.AddCommentWithContext(ctx, issue.Key, &jira.Comment{Body: body})
+			return resp, err
+		})
+		if err != nil {
+			log.Errorf("failed to comment on issue key:%s err:%v", issue.Key, err)
+			return retry, err
+		}
+		if res.Action == ActionNoop {
+			res.Action = ActionUpdated
+		}
+	}
+	firing := firingAlertKeys(data)
+	if previous != nil && equalStrings(previous, firing) {
+		return false, nil
+	}
+	return r.recordAlerts(ctx, issue.Key, firing)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
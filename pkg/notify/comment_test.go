@@ -0,0 +1,247 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify_Comment(t *testing.T) {
+	now := time.Now()
+	old := alertmanager.Alert{Status: alertmanager.AlertFiring, Labels: alertmanager.KV{"alertname": "old"}, StartsAt: now.Add(-time.Hour)}
+	// Whether an alert joined or left does not depend on when it started or ended.
+	joined := alertmanager.Alert{Status: alertmanager.AlertFiring, Labels: alertmanager.KV{"alertname": "joined"}, StartsAt: now.Add(-2 * time.Hour)}
+	left := alertmanager.Alert{Status: alertmanager.AlertResolved, Labels: alertmanager.KV{"alertname": "left"}, StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)}
+	leaving := left
+	leaving.Status = alertmanager.AlertFiring
+	data := func(alerts ...alertmanager.Alert) *alertmanager.Data {
+		return &alertmanager.Data{Status: alertmanager.AlertFiring, Alerts: alerts, GroupLabels: alertmanager.KV{"a": "b"}}
+	}
+
+	for _, tcase := range []struct {
+		name             string
+		comment          string
+		resolveFirst     bool
+		previous         *alertmanager.Data
+		forgetPrevious   bool
+		data             *alertmanager.Data
+		expectedComments []string
+		expectedAction   Action
+	}{
+		{
+			name:             "alert joined",
+			comment:          `{{ range .NewlyFiring }}+{{ .Labels.alertname }}{{ end }}{{ range .NewlyResolved }}-{{ .Labels.alertname }}{{ end }}`,
+			data:             data(old, joined),
+			expectedComments: []string{"+joined"},
+			expectedAction:   ActionUpdated,
+		},
+		{
+			name:             "alert resolved",
+			comment:          `{{ range .NewlyFiring }}+{{ .Labels.alertname }}{{ end }}{{ range .NewlyResolved }}-{{ .Labels.alertname }}{{ end }}`,
+			previous:         data(old, leaving),
+			data:             data(old, left),
+			expectedComments: []string{"-left"},
+			expectedAction:   ActionUpdated,
+		},
+		{
+			name:           "alert resolved before the previous notification",
+			comment:        `{{ range .NewlyFiring }}+{{ .Labels.alertname }}{{ end }}{{ range .NewlyResolved }}-{{ .Labels.alertname }}{{ end }}`,
+			previous:       data(old, left),
+			data:           data(old, left),
+			expectedAction: ActionNoop,
+		},
+		{
+			name:           "previous notification unknown",
+			comment:        `{{ range .NewlyFiring }}+{{ .Labels.alertname }}{{ end }}{{ range .NewlyResolved }}-{{ .Labels.alertname }}{{ end }}`,
+			forgetPrevious: true,
+			data:           data(old, joined),
+			expectedAction: ActionUpdated,
+		},
+		{
+			name:           "nothing changed",
+			comment:        `{{ range .NewlyFiring }}+{{ .Labels.alertname }}{{ end }}`,
+			data:           data(old),
+			expectedAction: ActionNoop,
+		},
+		{
+			name:             "reopened",
+			comment:          `{{ if .Reopened }}reopened for {{ len .Alerts }} alerts{{ end }}`,
+			resolveFirst:     true,
+			data:             data(old),
+			expectedComments: []string{"reopened for 1 alerts"},
+			expectedAction:   ActionReopened,
+		},
+		{
+			name:           "no comment template",
+			data:           data(old, joined),
+			expectedAction: ActionUpdated,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			fakeJira.transitionsByID["5678"] = jira.Transition{ID: "5678", Name: "reopened"}
+			conf := testReceiverConfig1()
+			conf.Comment = tcase.comment
+			receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+			previous := tcase.previous
+			if previous == nil {
+				previous = data(old)
+			}
+			res, err := receiver.Notify(context.Background(), previous, true)
+			require.NoError(t, err)
+			require.Equal(t, ActionCreated, res.Action)
+			if tcase.forgetPrevious {
+				delete(fakeJira.propertiesByKey, res.IssueKey)
+			}
+			if tcase.resolveFirst {
+				fakeJira.issuesByKey[res.IssueKey].Fields.Status.StatusCategory.Key = "done"
+			}
+
+			res, err = receiver.Notify(context.Background(), tcase.data, true)
+			require.NoError(t, err)
+			require.Equal(t, tcase.expectedAction, res.Action)
+			require.Equal(t, tcase.expectedComments, fakeJira.commentsByKey[res.IssueKey])
+			if tcase.comment != "" {
+				expected, err := json.Marshal(&alertsRecord{Firing: firingAlertKeys(tcase.data)})
+				require.NoError(t, err)
+				require.JSONEq(t, string(expected), string(fakeJira.propertiesByKey[res.IssueKey][alertsProperty]))
+			}
+		})
+	}
+}
+
+func TestNotify_DescriptionUpdate(t *testing.T) {
+	data := func(n int) *alertmanager.Data {
+		d := &alertmanager.Data{Status: alertmanager.AlertFiring, GroupLabels: alertmanager.KV{"a": "b"}}
+		for i := 0; i < n; i++ {
+			d.Alerts = append(d.Alerts, alertmanager.Alert{Status: alertmanager.AlertFiring})
+		}
+		return d
+	}
+
+	// description returns the description of the issue as it was sent, in the format of apiVersion.
+	description := func(issue *jira.Issue, apiVersion int) string {
+		if apiVersion != 3 {
+			return issue.Fields.Description
+		}
+		doc, ok := issue.Fields.Unknowns["description"].(*adfDoc)
+		if !ok {
+			return ""
+		}
+		return doc.Content[0].Content[0].Text
+	}
+
+	for _, tcase := range []struct {
+		policy              string
+		apiVersion          int
+		expectedOnCreate    string
+		expectedAfterNotify string
+	}{
+		{policy: config.DescriptionUpdateAlways, expectedOnCreate: "1", expectedAfterNotify: "2"},
+		{policy: config.DescriptionUpdateOnCreate, expectedOnCreate: "1", expectedAfterNotify: "1"},
+		{policy: config.DescriptionUpdateNever, expectedOnCreate: "", expectedAfterNotify: ""},
+		{policy: config.DescriptionUpdateAlways, apiVersion: 3, expectedOnCreate: "1", expectedAfterNotify: "2"},
+	} {
+		t.Run(fmt.Sprintf("%s v%d", tcase.policy, tcase.apiVersion), func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			conf := testReceiverConfig1()
+			conf.Description = `{{ len .Alerts }}`
+			conf.DescriptionUpdate = tcase.policy
+			conf.APIVersion = tcase.apiVersion
+			receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+			res, err := receiver.Notify(context.Background(), data(1), true)
+			require.NoError(t, err)
+			require.Equal(t, tcase.expectedOnCreate, description(fakeJira.issuesByKey[res.IssueKey], tcase.apiVersion))
+
+			_, err = receiver.Notify(context.Background(), data(2), true)
+			require.NoError(t, err)
+			require.Equal(t, tcase.expectedAfterNotify, description(fakeJira.issuesByKey[res.IssueKey], tcase.apiVersion))
+
+			// The same description is not sent again.
+			calls := fakeJira.calls["Issue.UpdateWithOptions"]
+			_, err = receiver.Notify(context.Background(), data(2), true)
+			require.NoError(t, err)
+			require.Equal(t, calls, fakeJira.calls["Issue.UpdateWithOptions"])
+		})
+	}
+}
+
+func TestNotify_AutoResolve(t *testing.T) {
+	resolved := &alertmanager.Data{Status: alertmanager.AlertResolved, GroupLabels: alertmanager.KV{"a": "b"}}
+	label := toGroupTicketLabel(context.Background(), resolved.GroupLabels, true)
+
+	for _, tcase := range []struct {
+		name               string
+		autoResolve        *config.AutoResolve
+		expectedResolution *jira.Resolution
+		expectedComments   []string
+	}{
+		{
+			name:        "state only",
+			autoResolve: &config.AutoResolve{State: "Done"},
+		},
+		{
+			name: "resolution and comment",
+			autoResolve: &config.AutoResolve{
+				State:      "Done",
+				Resolution: "Fixed",
+				Comment:    `Resolved automatically after {{ .Duration }} firing.`,
+			},
+			expectedResolution: &jira.Resolution{Name: "Fixed"},
+			// Without alerts, how long the group fired is not known.
+			expectedComments: []string{"Resolved automatically after 0s firing."},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			issue, _, err := fakeJira.CreateWithContext(context.Background(), &jira.Issue{Fields: &jira.IssueFields{
+				Project: jira.Project{Key: "abc"},
+				Labels:  []string{label},
+				Summary: "[RESOLVED] b ",
+			}})
+			require.NoError(t, err)
+			conf := testReceiverConfig1()
+			conf.AutoResolve = tcase.autoResolve
+			receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+			res, err := receiver.Notify(context.Background(), resolved, true)
+			require.NoError(t, err)
+			require.Equal(t, ActionResolved, res.Action)
+			require.Equal(t, "Done", fakeJira.issuesByKey[issue.Key].Fields.Status.StatusCategory.Key)
+			require.Equal(t, tcase.expectedResolution, fakeJira.issuesByKey[issue.Key].Fields.Resolution)
+			require.Equal(t, tcase.expectedComments, fakeJira.commentsByKey[issue.Key])
+		})
+	}
+}
+
+func TestNewResolveCommentData(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	data := &alertmanager.Data{Alerts: alertmanager.Alerts{
+		{StartsAt: now.Add(-time.Hour)},
+		{StartsAt: now.Add(-90*time.Minute - 500*time.Millisecond)},
+	}}
+	require.Equal(t, 90*time.Minute+time.Second, newResolveCommentData(data, now).Duration)
+	require.Equal(t, time.Duration(0), newResolveCommentData(&alertmanager.Data{}, now).Duration)
+}
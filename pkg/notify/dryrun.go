@@ -0,0 +1,152 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/andygrunwald/go-jira"
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// PlannedAction is a change to Jira that a receiver in dry-run mode would have made.
+type PlannedAction struct {
+	// Operation is "create", "update", "transition", "comment" or "property".
+	Operation string `json:"operation"`
+	// IssueKey is the issue updated, transitioned, commented on or whose property is set.
+	IssueKey string `json:"issue_key,omitempty"`
+	// Issue is the payload that would have been sent to create or update the issue.
+	Issue *jira.Issue `json:"issue,omitempty"`
+	// Transition is the transition that would have been done.
+	Transition *jira.Transition `json:"transition,omitempty"`
+	// Resolution is the resolution the transition would have set.
+	Resolution *jira.Resolution `json:"resolution,omitempty"`
+	// Comment is the comment that would have been added, by itself or with the transition.
+	Comment *jira.Comment `json:"comment,omitempty"`
+	// Property is the issue property that would have been set.
+	Property *IssueProperty `json:"property,omitempty"`
+}
+
+// IssueProperty is a property of an issue: a JSON value stored under a key.
+type IssueProperty struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// dryRunService passes the read-only requests of a receiver in dry-run mode on to Jira, and records the changes
+// instead of making them.
+type dryRunService struct {
+	next jiraIssueService
+
+	mtx     sync.Mutex
+	actions []PlannedAction
+	// transitions holds the transitions last listed for each issue, to name the planned ones.
+	transitions map[string][]jira.Transition
+}
+
+func newDryRunService(next jiraIssueService) *dryRunService {
+	return &dryRunService{next: next, transitions: map[string][]jira.Transition{}}
+}
+
+func (s *dryRunService) SearchWithContext(ctx context.Context, jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	return s.next.SearchWithContext(ctx, jql, options)
+}
+
+func (s *dryRunService) GetTransitionsWithContext(ctx context.Context, id string) ([]jira.Transition, *jira.Response, error) {
+	transitions, resp, err := s.next.GetTransitionsWithContext(ctx, id)
+	if err == nil {
+		s.mtx.Lock()
+		s.transitions[id] = transitions
+		s.mtx.Unlock()
+	}
+	return transitions, resp, err
+}
+
+func (s *dryRunService) GetDescriptionWithContext(ctx context.Context, issueID string) (json.RawMessage, *jira.Response, error) {
+	return s.next.GetDescriptionWithContext(ctx, issueID)
+}
+
+func (s *dryRunService) GetPropertyWithContext(ctx context.Context, issueID, key string) (json.RawMessage, *jira.Response, error) {
+	return s.next.GetPropertyWithContext(ctx, issueID, key)
+}
+
+func (s *dryRunService) CreateWithContext(_ context.Context, issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	s.plan(PlannedAction{Operation: "create", Issue: issue})
+	created := *issue
+	return &created, nil, nil
+}
+
+func (s *dryRunService) UpdateWithOptionsWithContext(_ context.Context, issue *jira.Issue, _ *jira.UpdateQueryOptions) (*jira.Issue, *jira.Response, error) {
+	s.plan(PlannedAction{Operation: "update", IssueKey: issue.Key, Issue: issue})
+	updated := *issue
+	return &updated, nil, nil
+}
+
+func (s *dryRunService) DoTransitionWithPayloadWithContext(_ context.Context, ticketID, payload interface{}) (*jira.Response, error) {
+	key := fmt.Sprint(ticketID)
+	p, _ := payload.(jira.CreateTransitionPayload)
+	a := PlannedAction{Operation: "transition", IssueKey: key, Transition: &jira.Transition{ID: p.Transition.ID}, Resolution: p.Fields.Resolution}
+	s.mtx.Lock()
+	for _, t := range s.transitions[key] {
+		if t.ID == p.Transition.ID {
+			a.Transition = &jira.Transition{ID: t.ID, Name: t.Name, To: t.To}
+		}
+	}
+	s.mtx.Unlock()
+	for _, c := range p.Update.Comment {
+		a.Comment = &jira.Comment{Body: c.Add.Body}
+	}
+	s.plan(a)
+	return nil, nil
+}
+
+func (s *dryRunService) AddCommentWithContext(_ context.Context, issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	s.plan(PlannedAction{Operation: "comment", IssueKey: issueID, Comment: comment})
+	added := *comment
+	return &added, nil, nil
+}
+
+func (s *dryRunService) SetPropertyWithContext(_ context.Context, issueID, key string, value interface{}) (*jira.Response, error) {
+	s.plan(PlannedAction{Operation: "property", IssueKey: issueID, Property: &IssueProperty{Key: key, Value: value}})
+	return nil, nil
+}
+
+// isReadOnlyAPI tells whether the Jira API api only reads: a receiver in dry-run mode sends it, and it is sent again
+// also when Jira may have received it without answering.
+func isReadOnlyAPI(api string) bool {
+	return api == "Issue.Search" || api == "Issue.GetTransitions" || api == "Issue.GetDescription" || api == "Issue.GetProperty"
+}
+
+func (s *dryRunService) plan(a PlannedAction) {
+	payload, _ := jsoniter.MarshalToString(a)
+	log.Infof("dry run, not sending to JIRA action:%s", payload)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.actions = append(s.actions, a)
+}
+
+// PlannedActions returns the changes the receiver would have made to Jira if it was not in dry-run mode, in order. It
+// returns nil if the receiver is not in dry-run mode.
+func (r *Receiver) PlannedActions() []PlannedAction {
+	if r.dryRun == nil {
+		return nil
+	}
+	r.dryRun.mtx.Lock()
+	defer r.dryRun.mtx.Unlock()
+	return append([]PlannedAction{}, r.dryRun.actions...)
+}
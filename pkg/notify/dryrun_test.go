@@ -0,0 +1,81 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/require"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+func TestNotify_DryRun(t *testing.T) {
+	conf := testReceiverConfig2()
+	conf.DryRun = true
+	data := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	label := toGroupTicketLabel(context.Background(), data.GroupLabels, true)
+
+	// Without an issue to reuse, the issue that would be created is planned.
+	fakeJira := newTestFakeJira()
+	receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+	res, err := receiver.Notify(context.Background(), data, true)
+	require.NoError(t, err)
+	require.Equal(t, &NotifyResult{Action: ActionCreated, APICalls: []string{"Issue.Search"}}, res)
+	require.Empty(t, fakeJira.issuesByKey)
+	require.Equal(t, map[string]int{"Issue.Search": 1}, fakeJira.calls)
+	actions := receiver.PlannedActions()
+	require.Len(t, actions, 1)
+	require.Equal(t, "create", actions[0].Operation)
+	require.Equal(t, "abc", actions[0].Issue.Fields.Project.Key)
+	require.Equal(t, []string{label}, actions[0].Issue.Fields.Labels)
+	require.Equal(t, "[FIRING:1] b ", actions[0].Issue.Fields.Summary)
+	require.Equal(t, "1", actions[0].Issue.Fields.Description)
+
+	// Updates and transitions of an existing issue are planned, after looking up the issue and its transitions.
+	_, _, err = fakeJira.CreateWithContext(context.Background(), &jira.Issue{Fields: &jira.IssueFields{
+		Project:  jira.Project{Key: "abc"},
+		Labels:   []string{label},
+		Summary:  "[FIRING:2] b",
+		Unknowns: tcontainer.NewMarshalMap(),
+	}})
+	require.NoError(t, err)
+	fakeJira.calls = map[string]int{}
+	receiver = NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+	key, _, err := receiver.Update(context.Background(), label, data)
+	require.NoError(t, err)
+	require.Equal(t, "1", key)
+	key, _, err = receiver.Close(context.Background(), label, data, "Done")
+	require.NoError(t, err)
+	require.Equal(t, "1", key)
+	require.Equal(t, map[string]int{"Issue.Search": 2, "Issue.GetTransitions": 1}, fakeJira.calls)
+	require.Equal(t, "[FIRING:2] b", fakeJira.issuesByKey["1"].Fields.Summary)
+	require.Equal(t, "NotDone", fakeJira.issuesByKey["1"].Fields.Status.StatusCategory.Key)
+
+	actions = receiver.PlannedActions()
+	require.Len(t, actions, 2)
+	require.Equal(t, "update", actions[0].Operation)
+	require.Equal(t, "1", actions[0].IssueKey)
+	require.Equal(t, "[FIRING:1] b ", actions[0].Issue.Fields.Summary)
+	require.Equal(t, PlannedAction{Operation: "transition", IssueKey: "1", Transition: &jira.Transition{ID: "1234", Name: "Done"}}, actions[1])
+
+	// Receivers not in dry-run mode plan nothing.
+	require.Nil(t, NewReceiver(testReceiverConfig2(), template.SimpleTemplate(), fakeJira, NewKeyedMutex()).PlannedActions())
+}
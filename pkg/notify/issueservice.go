@@ -0,0 +1,91 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// IssueService is the issue service of a Jira client that NewReceiver takes: the one of go-jira, and the requests
+// go-jira has no method for.
+type IssueService struct {
+	*jira.IssueService
+	client *jira.Client
+}
+
+// NewIssueService returns the IssueService of client.
+func NewIssueService(client *jira.Client) *IssueService {
+	return &IssueService{IssueService: client.Issue, client: client}
+}
+
+// GetDescriptionWithContext returns the description of the issue as Jira sends it: a string with version 2 of the REST
+// API and an Atlassian Document Format document with version 3, which go-jira cannot decode. It is null if the issue
+// has no description.
+func (s *IssueService) GetDescriptionWithContext(ctx context.Context, issueID string) (json.RawMessage, *jira.Response, error) {
+	req, err := s.client.NewRequestWithContext(ctx, http.MethodGet, "rest/api/2/issue/"+url.PathEscape(issueID)+"?fields=description", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var issue struct {
+		Fields struct {
+			Description json.RawMessage `json:"description"`
+		} `json:"fields"`
+	}
+	resp, err := s.client.Do(req, &issue)
+	if err != nil {
+		return nil, resp, jira.NewJiraError(resp, err)
+	}
+	return issue.Fields.Description, resp, nil
+}
+
+// GetPropertyWithContext returns the value of the issue property key, or nil if the issue has no such property.
+func (s *IssueService) GetPropertyWithContext(ctx context.Context, issueID, key string) (json.RawMessage, *jira.Response, error) {
+	req, err := s.client.NewRequestWithContext(ctx, http.MethodGet, propertyPath(issueID, key), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var property struct {
+		Value json.RawMessage `json:"value"`
+	}
+	resp, err := s.client.Do(req, &property)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, resp, nil
+		}
+		return nil, resp, jira.NewJiraError(resp, err)
+	}
+	return property.Value, resp, nil
+}
+
+// SetPropertyWithContext sets the issue property key to value, encoded as JSON.
+func (s *IssueService) SetPropertyWithContext(ctx context.Context, issueID, key string, value interface{}) (*jira.Response, error) {
+	req, err := s.client.NewRequestWithContext(ctx, http.MethodPut, propertyPath(issueID, key), value)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req, nil)
+	if err != nil {
+		return resp, jira.NewJiraError(resp, err)
+	}
+	return resp, nil
+}
+
+func propertyPath(issueID, key string) string {
+	return "rest/api/2/issue/" + url.PathEscape(issueID) + "/properties/" + url.PathEscape(key)
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueService_GetDescription(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/2/issue/ABC-1" || r.URL.Query().Get("fields") != "description" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"key":"ABC-1","fields":{"description":{"type":"doc","version":1,"content":[]}}}`))
+	}))
+	defer srv.Close()
+	client, err := jira.NewClient(nil, srv.URL)
+	require.NoError(t, err)
+	s := NewIssueService(client)
+
+	description, _, err := s.GetDescriptionWithContext(context.Background(), "ABC-1")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type":"doc","version":1,"content":[]}`, string(description))
+
+	_, resp, err := s.GetDescriptionWithContext(context.Background(), "ABC-2")
+	require.Error(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Locker serializes the handling of alert groups. Receivers hold the lock of a group's project and label from the
+// search for its issue until the issue was created, updated or transitioned, so that concurrent notifications for a
+// group cannot both find no issue and create one each.
+type Locker interface {
+	// Lock blocks until the lock of key is held or ctx is done. It returns the function releasing the lock.
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// lockKey returns the key of the lock of an alert group.
+func lockKey(project, groupLabel string) string {
+	return project + "/" + groupLabel
+}
+
+// KeyedMutex is a Locker holding one mutex per key, for as long as it is held or waited for.
+type KeyedMutex struct {
+	mtx   sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	// held has a value while the lock is held.
+	held chan struct{}
+	// refs counts the holder and the waiters.
+	refs int
+}
+
+// NewKeyedMutex returns a KeyedMutex with no locks held.
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// Lock implements Locker.
+func (m *KeyedMutex) Lock(ctx context.Context, key string) (func(), error) {
+	m.mtx.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{held: make(chan struct{}, 1)}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mtx.Unlock()
+
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		m.release(key, l)
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.held
+			m.release(key, l)
+		})
+	}, nil
+}
+
+func (m *KeyedMutex) release(key string, l *keyedLock) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// fileLockPollInterval is how often a FileLocker retries a lock file held by another process.
+const fileLockPollInterval = 20 * time.Millisecond
+
+// FileLocker is a Locker for several JIRAlert processes on one host, for example replicas sharing a node or a volume.
+// Each key is locked with flock(2) on a file in a shared directory; the files are left in place when unlocked.
+// Processes on different hosts are not serialized.
+type FileLocker struct {
+	dir   string
+	local *KeyedMutex
+}
+
+// NewFileLocker returns a FileLocker keeping its lock files in dir, which is created if needed. It fails on platforms
+// without flock(2).
+func NewFileLocker(dir string) (*FileLocker, error) {
+	if errFileLocks != nil {
+		return nil, errFileLocks
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.Wrap(err, "create lock directory")
+	}
+	return &FileLocker{dir: dir, local: NewKeyedMutex()}, nil
+}
+
+// Lock implements Locker.
+func (l *FileLocker) Lock(ctx context.Context, key string) (func(), error) {
+	// Goroutines of this process queue up on the local mutex instead of all polling the file.
+	unlockLocal, err := l.local.Lock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(l.dir, fmt.Sprintf("%x.lock", sha256.Sum256([]byte(key))))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		unlockLocal()
+		return nil, errors.Wrap(err, "open lock file")
+	}
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			_ = f.Close()
+			unlockLocal()
+			return nil, errors.Wrapf(err, "lock %s", path)
+		}
+		if locked {
+			break
+		}
+		select {
+		case <-time.After(fileLockPollInterval):
+		case <-ctx.Done():
+			_ = f.Close()
+			unlockLocal()
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// Closing the file releases the lock.
+			_ = f.Close()
+			unlockLocal()
+		})
+	}, nil
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package notify
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// errFileLocks is why FileLocker cannot be used on this platform.
+var errFileLocks = errors.New("file locks are not supported on this platform")
+
+func tryLockFile(*os.File) (bool, error) {
+	return false, errFileLocks
+}
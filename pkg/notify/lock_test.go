@@ -0,0 +1,132 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := NewKeyedMutex()
+	unlock, err := m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+
+	// Other keys are independent.
+	unlockB, err := m.Lock(context.Background(), "b")
+	require.NoError(t, err)
+	unlockB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = m.Lock(ctx, "a")
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	unlock()
+	unlock() // Releasing twice is harmless.
+	unlock, err = m.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	unlock()
+	require.Empty(t, m.locks)
+}
+
+func TestFileLocker(t *testing.T) {
+	dir := t.TempDir()
+	// Two lockers on one directory stand in for two processes.
+	l1, err := NewFileLocker(dir)
+	require.NoError(t, err)
+	l2, err := NewFileLocker(dir)
+	require.NoError(t, err)
+
+	unlock, err := l1.Lock(context.Background(), "a")
+	require.NoError(t, err)
+	unlockB, err := l2.Lock(context.Background(), "b")
+	require.NoError(t, err)
+	unlockB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l2.Lock(ctx, "a")
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock, err := l2.Lock(context.Background(), "a")
+		require.NoError(t, err)
+		close(acquired)
+		unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held by the other locker")
+	case <-time.After(2 * fileLockPollInterval):
+	}
+	unlock()
+	<-acquired
+}
+
+func TestNotify_ConcurrentGroup(t *testing.T) {
+	fileLockers := func(t *testing.T) func() Locker {
+		dir := t.TempDir()
+		return func() Locker {
+			l, err := NewFileLocker(dir)
+			require.NoError(t, err)
+			return l
+		}
+	}
+	for _, tcase := range []struct {
+		name string
+		// locker returns the locker of each of the concurrent receivers.
+		locker func(t *testing.T) func() Locker
+	}{
+		{
+			name: "keyed mutex",
+			locker: func(*testing.T) func() Locker {
+				m := NewKeyedMutex()
+				return func() Locker { return m }
+			},
+		},
+		{name: "file locks", locker: fileLockers},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			fakeJira.searchDelay = 10 * time.Millisecond
+			newLocker := tcase.locker(t)
+			data := &alertmanager.Data{
+				Status:      alertmanager.AlertFiring,
+				Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+				GroupLabels: alertmanager.KV{"a": "b"},
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				receiver := NewReceiver(testReceiverConfig1(), template.SimpleTemplate(), fakeJira, newLocker())
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := receiver.Notify(context.Background(), data, true)
+					require.NoError(t, err)
+				}()
+			}
+			wg.Wait()
+			// Without serialization every receiver finds no issue and creates one.
+			require.Len(t, fakeJira.issuesByKey, 1)
+		})
+	}
+}
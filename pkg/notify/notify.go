@@ -16,6 +16,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha512"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"reflect"
@@ -33,15 +34,23 @@ import (
 
 // TODO(bwplotka): Consider renaming this package to ticketer.
 
+// jiraIssueService is the part of IssueService a Receiver uses. Requests are cancelled when their context is done.
 type jiraIssueService interface {
-	Search(jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error)
-	GetTransitions(id string) ([]jira.Transition, *jira.Response, error)
-
-	Create(issue *jira.Issue) (*jira.Issue, *jira.Response, error)
-	UpdateWithOptions(issue *jira.Issue, opts *jira.UpdateQueryOptions) (*jira.Issue, *jira.Response, error)
-	DoTransition(ticketID, transitionID string) (*jira.Response, error)
+	SearchWithContext(ctx context.Context, jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error)
+	GetTransitionsWithContext(ctx context.Context, id string) ([]jira.Transition, *jira.Response, error)
+	GetDescriptionWithContext(ctx context.Context, issueID string) (json.RawMessage, *jira.Response, error)
+	GetPropertyWithContext(ctx context.Context, issueID, key string) (json.RawMessage, *jira.Response, error)
+
+	CreateWithContext(ctx context.Context, issue *jira.Issue) (*jira.Issue, *jira.Response, error)
+	UpdateWithOptionsWithContext(ctx context.Context, issue *jira.Issue, opts *jira.UpdateQueryOptions) (*jira.Issue, *jira.Response, error)
+	DoTransitionWithPayloadWithContext(ctx context.Context, ticketID, payload interface{}) (*jira.Response, error)
+	AddCommentWithContext(ctx context.Context, issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error)
+	SetPropertyWithContext(ctx context.Context, issueID, key string, value interface{}) (*jira.Response, error)
 }
 
+// ErrIssueNotFound is returned by Update and Close when no issue is tracked for the alert group.
+var ErrIssueNotFound = errors.New("no issue found")
+
 // Receiver wraps a specific Alertmanager receiver with its configuration and templates, creating/updating/reopening Jira issues based on Alertmanager notifications.
 type Receiver struct {
 	client jiraIssueService
@@ -49,113 +58,190 @@ type Receiver struct {
 	conf *config.ReceiverConfig
 	tmpl *template.Template
 
+	locker  Locker
+	retry   RetryPolicy
+	breaker *circuitBreaker
 	timeNow func() time.Time
+	// dryRun records the changes to Jira instead of making them if the receiver is in dry-run mode, and is nil
+	// otherwise.
+	dryRun *dryRunService
+}
+
+// NewReceiver creates a Receiver using the provided configuration, template and jiraIssueService. locker serializes the
+// handling of alert groups with the other receivers sharing it; with a nil locker they are not serialized, which is
+// only fit for previews. If the configuration sets dry_run, only read-only requests are sent through client; see PlannedActions. Receivers without a client, which
+// only preview, and in dry-run mode do not use the circuit breaker of the Jira instance, so that they neither create
+// it nor count towards it.
+func NewReceiver(c *config.ReceiverConfig, t *template.Template, client jiraIssueService, locker Locker) *Receiver {
+	r := &Receiver{conf: c, tmpl: t, client: client, locker: locker, retry: DefaultRetryPolicy, timeNow: time.Now}
+	if client != nil && !c.DryRun {
+		r.breaker = breakerFor(c.APIURL, breakerPolicy(c.CircuitBreaker))
+	}
+	if c.DryRun {
+		r.dryRun = newDryRunService(client)
+		r.client = r.dryRun
+	}
+	return r
 }
 
-// NewReceiver creates a Receiver using the provided configuration, template and jiraIssueService.
-func NewReceiver(c *config.ReceiverConfig, t *template.Template, client jiraIssueService) *Receiver {
-	return &Receiver{conf: c, tmpl: t, client: client, timeNow: time.Now}
+// Notify manages JIRA issues based on alertmanager webhook notify message. The result tells what was done, also when
+// it fails part way. Failures that may not happen again if the notification is retried are a *RetryableError.
+func (r *Receiver) Notify(ctx context.Context, data *alertmanager.Data, hashJiraLabel bool) (*NotifyResult, error) {
+	res := &NotifyResult{Action: ActionNoop, APICalls: []string{}}
+	retry, err := r.notify(withAPICalls(ctx, &res.APICalls), data, hashJiraLabel, res)
+	return res, retryable(retry, err)
 }
 
-// Notify manages JIRA issues based on alertmanager webhook notify message.
-func (r *Receiver) Notify(ctx context.Context, data *alertmanager.Data, hashJiraLabel bool) (string, bool, error) {
+func (r *Receiver) notify(ctx context.Context, data *alertmanager.Data, hashJiraLabel bool, res *NotifyResult) (bool, error) {
 	project, err := r.tmpl.Execute(r.conf.Project, data)
 	if err != nil {
-		log.Errorf("failed to execute project template", "err", err)
-		return "", false, errors.Wrap(err, "generate project from template")
+		log.Errorf("failed to execute project template: %v", err)
+		return false, errors.Wrap(err, "generate project from template")
 	}
 	issueGroupLabel := toGroupTicketLabel(ctx, data.GroupLabels, hashJiraLabel)
+	unlock, err := r.lockGroup(ctx, project, issueGroupLabel)
+	if err != nil {
+		return true, err
+	}
+	defer unlock()
 	issue, retry, err := r.findIssueToReuse(ctx, project, issueGroupLabel)
 	if err != nil {
-		log.Errorf("failed to find issue to reuse", "err", err)
-		return "", retry, err
+		log.Errorf("failed to find issue to reuse: %v", err)
+		return retry, err
 	}
 	// We want up to date title no matter what.
 	// This allows reflecting current group state if desired by user e.g {{ len $.Alerts.Firing() }}
 	issueSummary, err := r.tmpl.Execute(r.conf.Summary, data)
 	if err != nil {
-		log.Errorf("failed to execute summary template", "err", err)
-		return "", false, errors.Wrap(err, "generate summary from template")
+		log.Errorf("failed to execute summary template: %v", err)
+		return false, errors.Wrap(err, "generate summary from template")
 	}
-	log.Infof("issue summary", "summary", issueSummary)
+	log.Infof("issue summary: %s", issueSummary)
 	issueDesc, err := r.tmpl.Execute(r.conf.Description, data)
 	if err != nil {
-		log.Errorf("failed to execute description template", "err", err)
-		return "", false, errors.Wrap(err, "render issue description")
+		log.Errorf("failed to execute description template: %v", err)
+		return false, errors.Wrap(err, "render issue description")
 	}
 	log.Info(issue)
 	if issue != nil {
+		res.setIssue(r.conf.APIURL, issue.Key)
 		// Update summary if needed.
 		if issue.Fields.Summary != issueSummary {
-			retry, err := r.updateSummary(issue.Key, issueSummary)
+			retry, err := r.updateSummary(ctx, issue.Key, issueSummary)
 			if err != nil {
-				log.Errorf("failed to update summary", "err", err)
-				return "", retry, err
+				log.Errorf("failed to update summary: %v", err)
+				return retry, err
 			}
+			res.Action = ActionUpdated
 		}
 		log.Debug("msg", "found issue to reuse", "issue", issue.Key)
-		if issue.Fields.Description != issueDesc {
-			retry, err := r.updateDescription(issue.Key, issueDesc)
+		if r.updatesDescription() {
+			changed, retry, err := r.descriptionChanged(ctx, issue, issueDesc)
 			if err != nil {
-				log.Errorf("failed to update description", "err", err)
-				return "", retry, err
+				log.Errorf("failed to get description: %v", err)
+				return retry, err
+			}
+			if changed {
+				retry, err := r.updateDescription(ctx, issue.Key, issueDesc)
+				if err != nil {
+					log.Errorf("failed to update description: %v", err)
+					return retry, err
+				}
+				res.Action = ActionUpdated
 			}
 		}
 		log.Debug("msg", "issue found, reusing", "key", issue.Key, "id", issue.ID)
 		if cap(data.Alerts.Firing()) == 0 {
+			// The auto_resolve comment, if any, says it all, but the next comment still needs to know the alerts resolved.
+			if r.conf.AutoResolve == nil || r.conf.AutoResolve.Comment == "" {
+				if retry, err := r.comment(ctx, issue, data, false, res); err != nil {
+					return retry, err
+				}
+			} else if r.conf.Comment != "" {
+				if retry, err := r.recordAlerts(ctx, issue.Key, firingAlertKeys(data)); err != nil {
+					return retry, err
+				}
+			}
 			if r.conf.AutoResolve != nil {
 				log.Debug("msg", "no firing alert; resolving issue", "key", issue.Key, "label", issueGroupLabel)
-				retry, err := r.resolveIssue(issue.Key)
+				retry, err := r.resolveIssue(ctx, issue, data)
 				if err != nil {
-					log.Errorf("failed to resolve issue", "err", err)
-					return "", retry, err
+					log.Errorf("failed to resolve issue: %v", err)
+					return retry, err
 				}
-				log.Warningf("issue resolved", "key", issue.Key)
-				return "", false, nil
+				log.Warningf("issue resolved key:%s", issue.Key)
+				res.Action = ActionResolved
+				return false, nil
 			}
 			log.Debug("msg", "no firing alert; summary checked, nothing else to do.", "key", issue.Key, "label", issueGroupLabel)
-			return "", false, nil
+			return false, nil
 		}
 		log.Debug("msg", "issue found; summary checked, nothing else to do.", "key", issue.Key, "label", issueGroupLabel)
 		// The set of JIRA status categories is fixed, this is a safe check to make.
 		if issue.Fields.Status.StatusCategory.Key != "done" {
 			log.Debug("msg", "issue is unresolved, all is done", "key", issue.Key, "label", issueGroupLabel)
-			return "", false, nil
+			return r.comment(ctx, issue, data, false, res)
 		}
 		log.Debug("msg", "issue is resolved, reopening", "key", issue.Key, "label", issueGroupLabel)
 		if r.conf.WontFixResolution != "" && issue.Fields.Resolution != nil &&
 			issue.Fields.Resolution.Name == r.conf.WontFixResolution {
 			log.Info("msg", "issue was resolved as won't fix, not reopening", "key", issue.Key, "label", issueGroupLabel, "resolution", issue.Fields.Resolution.Name)
-			return "", false, nil
+			res.Action = ActionSkippedWontFix
+			return false, nil
 		}
 		log.Debug("msg", "issue is resolved, reopening", "key", issue.Key, "label", issueGroupLabel)
-		b, err := r.reopen(issue.Key)
+		if retry, err := r.reopen(ctx, issue); err != nil {
+			log.Errorf("failed to reopen issue key:%s err:%v", issue.Key, err)
+			return retry, err
+		}
 		log.Info("msg", "issue was recently resolved, reopening", "key", issue.Key, "label", issueGroupLabel)
-		return "", b, err
+		res.Action = ActionReopened
+		return r.comment(ctx, issue, data, true, res)
 	}
 	if cap(data.Alerts.Firing()) == 0 {
 		log.Debugf("no firing alert; nothing to do.label:%s", issueGroupLabel)
 	} else {
 		log.Warnf("no issue found, creating a new one label:%s", issueGroupLabel)
 	}
+	issue, err = r.newIssue(ctx, data, project, issueGroupLabel, issueSummary, issueDesc)
+	if err != nil {
+		return false, err
+	}
+	if retry, err := r.create(ctx, issue, project, issueGroupLabel); err != nil {
+		log.Errorf("failed to create issue: %v", err)
+		return retry, err
+	}
+	res.setIssue(r.conf.APIURL, issue.Key)
+	res.Action = ActionCreated
+	// The comment on the next notification tells what changed since this one.
+	if r.conf.Comment != "" && issue.Key != "" {
+		return r.recordAlerts(ctx, issue.Key, firingAlertKeys(data))
+	}
+	return false, nil
+}
+
+// newIssue renders the issue to create for data in project, with the given group label, summary and description.
+func (r *Receiver) newIssue(ctx context.Context, data *alertmanager.Data, project, groupLabel, summary, description string) (*jira.Issue, error) {
 	issueType, err := r.tmpl.Execute(r.conf.IssueType, data)
 	if err != nil {
-		return "", false, errors.Wrap(err, "render issue type")
+		return nil, errors.Wrap(err, "render issue type")
 	}
-	issue = &jira.Issue{
+	issue := &jira.Issue{
 		Fields: &jira.IssueFields{
-			Project:     jira.Project{Key: project},
-			Type:        jira.IssueType{Name: issueType},
-			Description: issueDesc,
-			Summary:     issueSummary,
-			Labels:      []string{issueGroupLabel},
-			Unknowns:    tcontainer.NewMarshalMap(),
+			Project:  jira.Project{Key: project},
+			Type:     jira.IssueType{Name: issueType},
+			Summary:  summary,
+			Labels:   []string{groupLabel},
+			Unknowns: tcontainer.NewMarshalMap(),
 		},
 	}
+	if r.conf.DescriptionUpdate != config.DescriptionUpdateNever {
+		r.setDescription(issue.Fields, description)
+	}
 	if r.conf.Priority != "" {
 		issuePrio, err := r.tmpl.Execute(r.conf.Priority, data)
 		if err != nil {
-			return "", false, errors.Wrap(err, "render issue priority")
+			return nil, errors.Wrap(err, "render issue priority")
 		}
 
 		issue.Fields.Priority = &jira.Priority{Name: issuePrio}
@@ -165,7 +251,7 @@ func (r *Receiver) Notify(ctx context.Context, data *alertmanager.Data, hashJira
 		for _, component := range r.conf.Components {
 			issueComp, err := r.tmpl.Execute(component, data)
 			if err != nil {
-				return "", false, errors.Wrap(err, "render issue component")
+				return nil, errors.Wrap(err, "render issue component")
 			}
 			issue.Fields.Components = append(issue.Fields.Components, &jira.Component{Name: issueComp})
 		}
@@ -177,12 +263,105 @@ func (r *Receiver) Notify(ctx context.Context, data *alertmanager.Data, hashJira
 	}
 	for key, value := range r.conf.Fields {
 		issue.Fields.Unknowns[key], err = deepCopyWithTemplate(ctx, value, r.tmpl, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "render field %s", key)
+		}
+	}
+	return issue, nil
+}
+
+// Update re-renders the summary, description and fields of the issue tracked for the given group label and writes
+// them to Jira, whether or not they changed. data is the template input, as in Notify.
+func (r *Receiver) Update(ctx context.Context, groupLabel string, data *alertmanager.Data) (string, bool, error) {
+	issue, unlock, retry, err := r.findGroupIssue(ctx, groupLabel, data)
+	if err != nil {
+		return "", retry, err
+	}
+	defer unlock()
+	summary, err := r.tmpl.Execute(r.conf.Summary, data)
+	if err != nil {
+		return "", false, errors.Wrap(err, "generate summary from template")
+	}
+	description, err := r.tmpl.Execute(r.conf.Description, data)
+	if err != nil {
+		return "", false, errors.Wrap(err, "render issue description")
+	}
+	issueUpdate := &jira.Issue{
+		Key: issue.Key,
+		Fields: &jira.IssueFields{
+			Summary:  summary,
+			Unknowns: tcontainer.NewMarshalMap(),
+		},
+	}
+	if r.conf.DescriptionUpdate != config.DescriptionUpdateNever {
+		r.setDescription(issueUpdate.Fields, description)
+	}
+	for key, value := range r.conf.Fields {
+		issueUpdate.Fields.Unknowns[key], err = deepCopyWithTemplate(ctx, value, r.tmpl, data)
 		if err != nil {
 			return "", false, err
 		}
 	}
-	b, err := r.create(issue)
-	return issue.Key, b, nil
+	if retry, err := r.update(ctx, issueUpdate); err != nil {
+		return "", retry, err
+	}
+	log.Infof("issue refreshed key:%s label:%s", issue.Key, groupLabel)
+	return issue.Key, false, nil
+}
+
+// Close transitions the issue tracked for the given group label into state. An issue that is already resolved is left
+// alone. data is only used to render the project.
+func (r *Receiver) Close(ctx context.Context, groupLabel string, data *alertmanager.Data, state string) (string, bool, error) {
+	issue, unlock, retry, err := r.findGroupIssue(ctx, groupLabel, data)
+	if err != nil {
+		return "", retry, err
+	}
+	defer unlock()
+	if issue.Fields.Status != nil && issue.Fields.Status.StatusCategory.Key == "done" {
+		log.Infof("issue already resolved key:%s label:%s", issue.Key, groupLabel)
+		return issue.Key, false, nil
+	}
+	if retry, err := r.doTransition(ctx, issue, state, jira.CreateTransitionPayload{}); err != nil {
+		return "", retry, err
+	}
+	log.Infof("issue closed key:%s label:%s state:%s", issue.Key, groupLabel, state)
+	return issue.Key, false, nil
+}
+
+// findGroupIssue locks the alert group with the given label and returns the most recent issue carrying the label in
+// the receiver's project. Unless it fails, the caller must release the lock with the returned function.
+func (r *Receiver) findGroupIssue(ctx context.Context, groupLabel string, data *alertmanager.Data) (*jira.Issue, func(), bool, error) {
+	project, err := r.tmpl.Execute(r.conf.Project, data)
+	if err != nil {
+		return nil, nil, false, errors.Wrap(err, "generate project from template")
+	}
+	unlock, err := r.lockGroup(ctx, project, groupLabel)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	issue, retry, err := r.search(ctx, project, groupLabel)
+	if err != nil {
+		unlock()
+		return nil, nil, retry, err
+	}
+	if issue == nil {
+		unlock()
+		return nil, nil, false, errors.Wrapf(ErrIssueNotFound, "project %s label %s", project, groupLabel)
+	}
+	return issue, unlock, false, nil
+}
+
+// lockGroup takes the lock of the alert group with the given project and label, if the receiver has a locker. Failing
+// to, typically because ctx is done first, is worth a retry.
+func (r *Receiver) lockGroup(ctx context.Context, project, groupLabel string) (func(), error) {
+	if r.locker == nil {
+		return func() {}, nil
+	}
+	unlock, err := r.locker.Lock(ctx, lockKey(project, groupLabel))
+	if err != nil {
+		return nil, errors.Wrapf(err, "lock alert group %s in project %s", groupLabel, project)
+	}
+	return unlock, nil
 }
 
 // deepCopyWithTemplate returns a deep copy of a map/slice/array/string/int/bool or combination thereof, executing the
@@ -271,11 +450,19 @@ func (r *Receiver) search(ctx context.Context, project, issueLabel string) (*jir
 		Fields:     []string{"summary", "status", "resolution", "resolutiondate"},
 		MaxResults: 2,
 	}
+	// Descriptions of version 3 are in the Atlassian Document Format, which go-jira cannot decode; descriptionChanged
+	// fetches them.
+	if r.conf.APIVersion != 3 {
+		options.Fields = append(options.Fields, "description")
+	}
 
 	log.Debug("msg", "search", "query", query, "options", fmt.Sprintf("%+v", options))
-	issues, resp, err := r.client.Search(query, options)
+	var issues []jira.Issue
+	retry, err := r.do(ctx, "Issue.Search", func() (resp *jira.Response, err error) {
+		issues, resp, err = r.client.SearchWithContext(ctx, query, options)
+		return resp, err
+	})
 	if err != nil {
-		retry, err := handleJiraErrResponse("Issue.Search", resp, err)
 		return nil, retry, err
 	}
 
@@ -313,7 +500,7 @@ func (r *Receiver) findIssueToReuse(ctx context.Context, project string, issueGr
 	return issue, false, nil
 }
 
-func (r *Receiver) updateSummary(issueKey string, summary string) (bool, error) {
+func (r *Receiver) updateSummary(ctx context.Context, issueKey string, summary string) (bool, error) {
 	log.Debug("msg", "updating issue with new summary", "key", issueKey, "summary", summary)
 
 	issueUpdate := &jira.Issue{
@@ -322,56 +509,148 @@ func (r *Receiver) updateSummary(issueKey string, summary string) (bool, error)
 			Summary: summary,
 		},
 	}
-	issue, resp, err := r.client.UpdateWithOptions(issueUpdate, nil)
-	if err != nil {
-		return handleJiraErrResponse("Issue.UpdateWithOptions", resp, err)
+	if retry, err := r.update(ctx, issueUpdate); err != nil {
+		return retry, err
 	}
-	log.Debug("msg", "issue summary updated", "key", issue.Key, "id", issue.ID)
+	log.Debug("msg", "issue summary updated", "key", issueKey)
 	return false, nil
 }
 
-func (r *Receiver) updateDescription(issueKey string, description string) (bool, error) {
+func (r *Receiver) updateDescription(ctx context.Context, issueKey string, description string) (bool, error) {
 	log.Debug("msg", "updating issue with new description", "key", issueKey, "description", description)
 
 	issueUpdate := &jira.Issue{
-		Key: issueKey,
-		Fields: &jira.IssueFields{
-			Description: description,
-		},
+		Key:    issueKey,
+		Fields: &jira.IssueFields{},
 	}
-	issue, resp, err := r.client.UpdateWithOptions(issueUpdate, nil)
-	if err != nil {
-		return handleJiraErrResponse("Issue.UpdateWithOptions", resp, err)
+	r.setDescription(issueUpdate.Fields, description)
+	if retry, err := r.update(ctx, issueUpdate); err != nil {
+		return retry, err
 	}
-	log.Debug("msg", "issue summary updated", "key", issue.Key, "id", issue.ID)
+	log.Debug("msg", "issue description updated", "key", issueKey)
 	return false, nil
 }
 
-func (r *Receiver) reopen(issueKey string) (bool, error) {
-	return r.doTransition(issueKey, r.conf.ReopenState)
+// update writes the fields set in issueUpdate to the issue with its key.
+func (r *Receiver) update(ctx context.Context, issueUpdate *jira.Issue) (bool, error) {
+	return r.do(ctx, "Issue.UpdateWithOptions", func() (resp *jira.Response, err error) {
+		_, resp, err = r.client.UpdateWithOptionsWithContext(ctx, issueUpdate, nil)
+		return resp, err
+	})
 }
 
-func (r *Receiver) create(issue *jira.Issue) (bool, error) {
-	log.Debug("msg", "create", "issue", fmt.Sprintf("%+v", *issue.Fields))
-	newIssue, resp, err := r.client.Create(issue)
+// setDescription sets the description of fields in the format of the receiver's API version: plain text for version 2
+// and the Atlassian Document Format for version 3.
+func (r *Receiver) setDescription(fields *jira.IssueFields, description string) {
+	if r.conf.APIVersion != 3 {
+		fields.Description = description
+		return
+	}
+	if fields.Unknowns == nil {
+		fields.Unknowns = tcontainer.NewMarshalMap()
+	}
+	fields.Unknowns["description"] = toADF(description)
+}
+
+// descriptionChanged tells whether the description of issue, as search returned it, differs from description. With
+// version 3 of the REST API, the description is fetched and compared as the Atlassian Document Format document that
+// updateDescription would send.
+func (r *Receiver) descriptionChanged(ctx context.Context, issue *jira.Issue, description string) (bool, bool, error) {
+	if r.conf.APIVersion != 3 {
+		return issue.Fields.Description != description, false, nil
+	}
+	var current json.RawMessage
+	retry, err := r.do(ctx, "Issue.GetDescription", func() (resp *jira.Response, err error) {
+		current, resp, err = r.client.GetDescriptionWithContext(ctx, issue.Key)
+		return resp, err
+	})
+	if err != nil {
+		return false, retry, err
+	}
+	if len(current) == 0 || string(current) == "null" {
+		return description != "", false, nil
+	}
+	rendered, err := json.Marshal(toADF(description))
 	if err != nil {
-		return handleJiraErrResponse("Issue.Create", resp, err)
+		return false, false, errors.Wrap(err, "render description")
 	}
-	*issue = *newIssue
+	// Compare the documents rather than their encodings, which may differ in key order and spacing.
+	var got, want interface{}
+	if err := json.Unmarshal(current, &got); err != nil {
+		return false, false, errors.Wrap(err, "decode description")
+	}
+	if err := json.Unmarshal(rendered, &want); err != nil {
+		return false, false, errors.Wrap(err, "decode rendered description")
+	}
+	return !reflect.DeepEqual(got, want), false, nil
+}
 
-	log.Info("msg", "issue created", "key", issue.Key, "id", issue.ID)
-	return false, nil
+// updatesDescription tells whether the description of existing issues is rewritten when it changes.
+func (r *Receiver) updatesDescription() bool {
+	return r.conf.DescriptionUpdate == "" || r.conf.DescriptionUpdate == config.DescriptionUpdateAlways
+}
+
+func (r *Receiver) reopen(ctx context.Context, issue *jira.Issue) (bool, error) {
+	return r.doTransition(ctx, issue, r.conf.ReopenState, jira.CreateTransitionPayload{})
+}
+
+// create creates issue, for the alert group with the given label in project, and sets it to the issue created. Jira may
+// create the issue without answering, so when it does not answer the issue is searched for by its group label before
+// it is created again.
+func (r *Receiver) create(ctx context.Context, issue *jira.Issue, project, groupLabel string) (bool, error) {
+	log.Debug("msg", "create", "issue", fmt.Sprintf("%+v", *issue.Fields))
+	for attempt := 1; ; attempt++ {
+		var (
+			newIssue   *jira.Issue
+			unanswered bool
+		)
+		retry, err := r.do(ctx, "Issue.Create", func() (resp *jira.Response, err error) {
+			newIssue, resp, err = r.client.CreateWithContext(ctx, issue)
+			unanswered = err != nil && (resp == nil || resp.Response == nil) && isTransportFailure(err) && !isNotSent(err)
+			return resp, err
+		})
+		if err == nil {
+			*issue = *newIssue
+			log.Info("msg", "issue created", "key", issue.Key, "id", issue.ID)
+			return false, nil
+		}
+		if !unanswered || ctx.Err() != nil {
+			return retry, err
+		}
+		log.Warnf("JIRA did not answer the creation of the issue, searching for it label:%s attempt:%d err:%v", groupLabel, attempt, err)
+		found, retry, searchErr := r.findIssueToReuse(ctx, project, groupLabel)
+		if searchErr != nil {
+			return retry, searchErr
+		}
+		if found != nil {
+			*issue = *found
+			log.Info("msg", "issue created without an answer", "key", issue.Key, "id", issue.ID)
+			return false, nil
+		}
+		if attempt >= r.retry.MaxAttempts {
+			// Notifying again searches for the issue before creating it too.
+			return true, err
+		}
+	}
 }
 
-func handleJiraErrResponse(api string, resp *jira.Response, err error) (bool, error) {
+func handleJiraErrResponse(ctx context.Context, api string, resp *jira.Response, err error) (bool, error) {
 	if resp == nil || resp.Request == nil {
 		log.Debug("msg", "handleJiraErrResponse", "api", api, "err", err)
 	} else {
 		log.Debug("msg", "handleJiraErrResponse", "api", api, "err", err, "url", resp.Request.URL)
 	}
 
-	if resp != nil && resp.StatusCode/100 != 2 {
-		retry := resp.StatusCode == 500 || resp.StatusCode == 503
+	if resp == nil || resp.Response == nil {
+		// No response: Jira could not be reached, or did not answer in time, unless the request could not be built.
+		// Requests cut short because the notification was cancelled or timed out are worth sending again later, but
+		// requests changing Jira only if they never reached it.
+		retry := ctx.Err() != nil || errors.Is(err, context.Canceled) || isNotSent(err) ||
+			isReadOnlyAPI(api) && isTransportFailure(err)
+		return retry, errors.Wrapf(err, "JIRA request %s failed", api)
+	}
+	if resp.StatusCode/100 != 2 {
+		retry := isRetryableStatus(resp.StatusCode)
 		body, _ := ioutil.ReadAll(resp.Body)
 		// go-jira error message is not particularly helpful, replace it
 		return retry, errors.Errorf("JIRA request %s returned status %s, body %q", resp.Request.URL, resp.Status, string(body))
@@ -379,28 +658,20 @@ func handleJiraErrResponse(api string, resp *jira.Response, err error) (bool, er
 	return false, errors.Wrapf(err, "JIRA request %s failed", api)
 }
 
-func (r *Receiver) resolveIssue(issueKey string) (bool, error) {
-	return r.doTransition(issueKey, r.conf.AutoResolve.State)
-}
-
-func (r *Receiver) doTransition(issueKey string, transitionState string) (bool, error) {
-	transitions, resp, err := r.client.GetTransitions(issueKey)
-	if err != nil {
-		return handleJiraErrResponse("Issue.GetTransitions", resp, err)
+// resolveIssue transitions issue into the auto_resolve state, setting its resolution and adding its comment if the
+// receiver configures them.
+func (r *Receiver) resolveIssue(ctx context.Context, issue *jira.Issue, data *alertmanager.Data) (bool, error) {
+	var payload jira.CreateTransitionPayload
+	if r.conf.AutoResolve.Resolution != "" {
+		payload.Fields.Resolution = &jira.Resolution{Name: r.conf.AutoResolve.Resolution}
 	}
-
-	for _, t := range transitions {
-		if t.Name == transitionState {
-			log.Debug("msg", fmt.Sprintf("transition %s", transitionState), "key", issueKey, "transitionID", t.ID)
-			resp, err = r.client.DoTransition(issueKey, t.ID)
-			if err != nil {
-				return handleJiraErrResponse("Issue.DoTransition", resp, err)
-			}
-
-			log.Debug("msg", transitionState, "key", issueKey)
-			return false, nil
+	if r.conf.AutoResolve.Comment != "" {
+		body, err := r.tmpl.Execute(r.conf.AutoResolve.Comment, newResolveCommentData(data, r.timeNow()))
+		if err != nil {
+			log.Errorf("failed to execute auto_resolve comment template: %v", err)
+			return false, errors.Wrap(err, "render auto_resolve comment")
 		}
+		payload.Update.Comment = []jira.TransitionPayloadComment{{Add: jira.TransitionPayloadCommentBody{Body: body}}}
 	}
-	return false, errors.Errorf("JIRA state %q does not exist or no transition possible for %s", r.conf.ReopenState, issueKey)
-
+	return r.doTransition(ctx, issue, r.conf.AutoResolve.State, payload)
 }
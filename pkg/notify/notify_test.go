@@ -13,8 +13,13 @@
 package notify
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -34,11 +39,29 @@ func TestToGroupTicketLabel(t *testing.T) {
 }
 
 type fakeJira struct {
+	// searchDelay is how long Search takes, to widen the window between search and create in race tests.
+	searchDelay time.Duration
+
+	mtx sync.Mutex
 	// Key = ID for simplification.
 	issuesByKey map[string]*jira.Issue
 	keysByQuery map[string][]string
 
 	transitionsByID map[string]jira.Transition
+	// workflow, if set, holds the transitions out of each status by status name, instead of transitionsByID.
+	workflow map[string][]jira.Transition
+	// commentsByKey holds the bodies of the comments added to each issue.
+	commentsByKey map[string][]string
+	// propertiesByKey holds the properties of each issue by property key.
+	propertiesByKey map[string]map[string]json.RawMessage
+
+	// unansweredCreates makes the next creations fail without a response, as if the connection dropped before Jira
+	// answered; each tells whether Jira created the issue all the same.
+	unansweredCreates []bool
+	// failures holds, by API name, the responses that the next calls fail with.
+	failures map[string][]*jira.Response
+	// calls counts the calls by API name.
+	calls map[string]int
 }
 
 func newTestFakeJira() *fakeJira {
@@ -46,10 +69,41 @@ func newTestFakeJira() *fakeJira {
 		issuesByKey:     map[string]*jira.Issue{},
 		transitionsByID: map[string]jira.Transition{"1234": {ID: "1234", Name: "Done"}},
 		keysByQuery:     map[string][]string{},
+		commentsByKey:   map[string][]string{},
+		propertiesByKey: map[string]map[string]json.RawMessage{},
+		failures:        map[string][]*jira.Response{},
+		calls:           map[string]int{},
+	}
+}
+
+// call records a call of api and returns the failure queued for it, if any, or the error of ctx if it is done. f.mtx
+// must be held.
+func (f *fakeJira) call(ctx context.Context, api string) (*jira.Response, error) {
+	f.calls[api]++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(f.failures[api]) == 0 {
+		return nil, nil
 	}
+	resp := f.failures[api][0]
+	f.failures[api] = f.failures[api][1:]
+	return resp, errors.Errorf("request failed with status %d", resp.StatusCode)
 }
 
-func (f *fakeJira) Search(jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+func (f *fakeJira) SearchWithContext(ctx context.Context, jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	timer := time.NewTimer(f.searchDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.Search"); err != nil {
+		return nil, resp, err
+	}
 	var issues []jira.Issue
 	for _, key := range f.keysByQuery[jql] {
 		issue := jira.Issue{Key: key, Fields: &jira.IssueFields{}}
@@ -57,6 +111,8 @@ func (f *fakeJira) Search(jql string, options *jira.SearchOptions) ([]jira.Issue
 			switch field {
 			case "summary":
 				issue.Fields.Summary = f.issuesByKey[key].Fields.Summary
+			case "description":
+				issue.Fields.Description = f.issuesByKey[key].Fields.Description
 			case "resolution":
 				if f.issuesByKey[key].Fields.Resolution == nil {
 					continue
@@ -68,6 +124,7 @@ func (f *fakeJira) Search(jql string, options *jira.SearchOptions) ([]jira.Issue
 				issue.Fields.Resolutiondate = f.issuesByKey[key].Fields.Resolutiondate
 			case "status":
 				issue.Fields.Status = &jira.Status{
+					Name:           f.issuesByKey[key].Fields.Status.Name,
 					StatusCategory: f.issuesByKey[key].Fields.Status.StatusCategory,
 				}
 			}
@@ -86,7 +143,15 @@ func (f *fakeJira) Search(jql string, options *jira.SearchOptions) ([]jira.Issue
 	return issues, nil, nil
 }
 
-func (f *fakeJira) GetTransitions(_ string) ([]jira.Transition, *jira.Response, error) {
+func (f *fakeJira) GetTransitionsWithContext(ctx context.Context, id string) ([]jira.Transition, *jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.GetTransitions"); err != nil {
+		return nil, resp, err
+	}
+	if f.workflow != nil {
+		return f.workflow[f.issuesByKey[id].Fields.Status.Name], nil, nil
+	}
 	var trs []jira.Transition
 	for _, tr := range f.transitionsByID {
 		trs = append(trs, tr)
@@ -94,13 +159,47 @@ func (f *fakeJira) GetTransitions(_ string) ([]jira.Transition, *jira.Response,
 	return trs, nil, nil
 }
 
-func (f *fakeJira) Create(issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+func (f *fakeJira) GetDescriptionWithContext(ctx context.Context, issueID string) (json.RawMessage, *jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.GetDescription"); err != nil {
+		return nil, resp, err
+	}
+	issue, ok := f.issuesByKey[issueID]
+	if !ok {
+		return nil, nil, errors.Errorf("no such issue %s", issueID)
+	}
+	// Descriptions of version 3 are set as documents among the unknown fields.
+	if d, ok := issue.Fields.Unknowns["description"]; ok {
+		raw, err := json.Marshal(d)
+		return raw, nil, err
+	}
+	raw, err := json.Marshal(issue.Fields.Description)
+	return raw, nil, err
+}
+
+func (f *fakeJira) CreateWithContext(ctx context.Context, issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.Create"); err != nil {
+		return nil, resp, err
+	}
+	unanswered := len(f.unansweredCreates) > 0
+	if unanswered {
+		created := f.unansweredCreates[0]
+		f.unansweredCreates = f.unansweredCreates[1:]
+		if !created {
+			return nil, nil, &url.Error{Op: "Post", URL: "https://jira.example.com/rest/api/2/issue", Err: io.ErrUnexpectedEOF}
+		}
+	}
 	issue.Key = fmt.Sprintf("%d", len(f.issuesByKey)+1)
 	issue.ID = issue.Key
 	issue.Fields.Status = &jira.Status{
 		StatusCategory: jira.StatusCategory{Key: "NotDone"},
 	}
-	f.issuesByKey[issue.Key] = issue
+	// Store a copy, so that callers writing to issue do not race with Search.
+	stored := *issue
+	f.issuesByKey[issue.Key] = &stored
 
 	// Assuming single label.
 	query := fmt.Sprintf(
@@ -110,10 +209,18 @@ func (f *fakeJira) Create(issue *jira.Issue) (*jira.Issue, *jira.Response, error
 	)
 	f.keysByQuery[query] = append(f.keysByQuery[query], issue.Key)
 
+	if unanswered {
+		return nil, nil, &url.Error{Op: "Post", URL: "https://jira.example.com/rest/api/2/issue", Err: io.ErrUnexpectedEOF}
+	}
 	return issue, nil, nil
 }
 
-func (f *fakeJira) UpdateWithOptions(old *jira.Issue, _ *jira.UpdateQueryOptions) (*jira.Issue, *jira.Response, error) {
+func (f *fakeJira) UpdateWithOptionsWithContext(ctx context.Context, old *jira.Issue, _ *jira.UpdateQueryOptions) (*jira.Issue, *jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.UpdateWithOptions"); err != nil {
+		return nil, resp, err
+	}
 	issue, ok := f.issuesByKey[old.Key]
 	if !ok {
 		return nil, nil, errors.Errorf("no such issue %s", old.Key)
@@ -127,25 +234,97 @@ func (f *fakeJira) UpdateWithOptions(old *jira.Issue, _ *jira.UpdateQueryOptions
 		issue.Fields.Description = old.Fields.Description
 	}
 
+	for k, v := range old.Fields.Unknowns {
+		issue.Fields.Unknowns[k] = v
+	}
 	f.issuesByKey[issue.Key] = issue
 	return issue, nil, nil
 }
 
-func (f *fakeJira) DoTransition(ticketID, transitionID string) (*jira.Response, error) {
-	issue, ok := f.issuesByKey[ticketID]
+func (f *fakeJira) DoTransitionWithPayloadWithContext(ctx context.Context, ticketID, payload interface{}) (*jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.DoTransition"); err != nil {
+		return resp, err
+	}
+	key := ticketID.(string)
+	issue, ok := f.issuesByKey[key]
 	if !ok {
-		return nil, errors.Errorf("no such issue %s", ticketID)
+		return nil, errors.Errorf("no such issue %s", key)
 	}
 
-	tr, ok := f.transitionsByID[transitionID]
-	if !ok {
-		return nil, errors.Errorf("no such transition %s", tr.ID)
+	p := payload.(jira.CreateTransitionPayload)
+	if f.workflow != nil {
+		ok = false
+		for _, tr := range f.workflow[issue.Fields.Status.Name] {
+			if tr.ID == p.Transition.ID {
+				issue.Fields.Status = &jira.Status{Name: tr.To.Name, StatusCategory: tr.To.StatusCategory}
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, errors.Errorf("no transition %s out of %s", p.Transition.ID, issue.Fields.Status.Name)
+		}
+	} else {
+		tr, ok := f.transitionsByID[p.Transition.ID]
+		if !ok {
+			return nil, errors.Errorf("no such transition %s", p.Transition.ID)
+		}
+		issue.Fields.Status.StatusCategory.Key = tr.Name
+	}
+	if p.Fields.Resolution != nil {
+		issue.Fields.Resolution = p.Fields.Resolution
+	}
+	for _, c := range p.Update.Comment {
+		f.commentsByKey[key] = append(f.commentsByKey[key], c.Add.Body)
 	}
+	f.issuesByKey[issue.Key] = issue
 
-	issue.Fields.Status.StatusCategory.Key = tr.Name
+	return nil, nil
+}
 
-	f.issuesByKey[issue.Key] = issue
+func (f *fakeJira) AddCommentWithContext(ctx context.Context, issueID string, comment *jira.Comment) (*jira.Comment, *jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.AddComment"); err != nil {
+		return nil, resp, err
+	}
+	if _, ok := f.issuesByKey[issueID]; !ok {
+		return nil, nil, errors.Errorf("no such issue %s", issueID)
+	}
+	f.commentsByKey[issueID] = append(f.commentsByKey[issueID], comment.Body)
+	return comment, nil, nil
+}
+
+func (f *fakeJira) GetPropertyWithContext(ctx context.Context, issueID, key string) (json.RawMessage, *jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.GetProperty"); err != nil {
+		return nil, resp, err
+	}
+	if _, ok := f.issuesByKey[issueID]; !ok {
+		return nil, nil, errors.Errorf("no such issue %s", issueID)
+	}
+	return f.propertiesByKey[issueID][key], nil, nil
+}
 
+func (f *fakeJira) SetPropertyWithContext(ctx context.Context, issueID, key string, value interface{}) (*jira.Response, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if resp, err := f.call(ctx, "Issue.SetProperty"); err != nil {
+		return resp, err
+	}
+	if _, ok := f.issuesByKey[issueID]; !ok {
+		return nil, errors.Errorf("no such issue %s", issueID)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if f.propertiesByKey[issueID] == nil {
+		f.propertiesByKey[issueID] = map[string]json.RawMessage{}
+	}
+	f.propertiesByKey[issueID][key] = raw
 	return nil, nil
 }
 
@@ -229,7 +408,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 			inputConfig: testReceiverConfig1(),
 			initJira: func(t *testing.T) *fakeJira {
 				f := newTestFakeJira()
-				_, _, err := f.Create(&jira.Issue{
+				_, _, err := f.CreateWithContext(context.Background(), &jira.Issue{
 					ID:  "1",
 					Key: "1",
 					Fields: &jira.IssueFields{
@@ -271,7 +450,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 			inputConfig: testReceiverConfig2(),
 			initJira: func(t *testing.T) *fakeJira {
 				f := newTestFakeJira()
-				_, _, err := f.Create(&jira.Issue{
+				_, _, err := f.CreateWithContext(context.Background(), &jira.Issue{
 					ID:  "1",
 					Key: "1",
 					Fields: &jira.IssueFields{
@@ -315,7 +494,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 			inputConfig: testReceiverConfig1(),
 			initJira: func(t *testing.T) *fakeJira {
 				f := newTestFakeJira()
-				_, _, err := f.Create(&jira.Issue{
+				_, _, err := f.CreateWithContext(context.Background(), &jira.Issue{
 					ID:  "1",
 					Key: "1",
 					Fields: &jira.IssueFields{
@@ -370,7 +549,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 			inputConfig: testReceiverConfig1(),
 			initJira: func(t *testing.T) *fakeJira {
 				f := newTestFakeJira()
-				_, _, err := f.Create(&jira.Issue{
+				_, _, err := f.CreateWithContext(context.Background(), &jira.Issue{
 					ID:  "1",
 					Key: "1",
 					Fields: &jira.IssueFields{
@@ -425,7 +604,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 			inputConfig: testReceiverConfig1(),
 			initJira: func(t *testing.T) *fakeJira {
 				f := newTestFakeJira()
-				_, _, err := f.Create(&jira.Issue{
+				_, _, err := f.CreateWithContext(context.Background(), &jira.Issue{
 					ID:  "1",
 					Key: "1",
 					Fields: &jira.IssueFields{
@@ -502,7 +681,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 			},
 			initJira: func(t *testing.T) *fakeJira {
 				f := newTestFakeJira()
-				_, _, err := f.Create(&jira.Issue{
+				_, _, err := f.CreateWithContext(context.Background(), &jira.Issue{
 					ID:  "1",
 					Key: "1",
 					Fields: &jira.IssueFields{
@@ -541,6 +720,7 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 				tcase.inputConfig,
 				template.SimpleTemplate(),
 				fakeJira,
+				NewKeyedMutex(),
 			)
 
 			receiver.timeNow = func() time.Time {
@@ -555,3 +735,44 @@ func TestNotify_JIRAInteraction(t *testing.T) {
 		}
 	}
 }
+
+func TestReceiver_UpdateClose(t *testing.T) {
+	conf := testReceiverConfig2()
+	conf.Fields = map[string]interface{}{"customfield_10001": "{{ .CommonLabels.team }}"}
+	data := &alertmanager.Data{
+		Status:       alertmanager.AlertFiring,
+		Alerts:       alertmanager.Alerts{{Status: alertmanager.AlertFiring}, {Status: alertmanager.AlertFiring}},
+		GroupLabels:  alertmanager.KV{"a": "b"},
+		CommonLabels: alertmanager.KV{"a": "b", "team": "sre"},
+	}
+	label := toGroupTicketLabel(context.Background(), data.GroupLabels, true)
+
+	fakeJira := newTestFakeJira()
+	_, _, err := fakeJira.CreateWithContext(context.Background(), &jira.Issue{Fields: &jira.IssueFields{
+		Project:  jira.Project{Key: "abc"},
+		Labels:   []string{label},
+		Summary:  "[FIRING:1] b",
+		Unknowns: tcontainer.NewMarshalMap(),
+	}})
+	require.NoError(t, err)
+	receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+	_, _, err = receiver.Update(context.Background(), "ALERT{a=\"c\"}", data)
+	require.True(t, errors.Is(err, ErrIssueNotFound), "%v", err)
+
+	key, retry, err := receiver.Update(context.Background(), label, data)
+	require.NoError(t, err)
+	require.False(t, retry)
+	require.Equal(t, "1", key)
+	issue := fakeJira.issuesByKey["1"]
+	require.Equal(t, "[FIRING:2] b (sre)", issue.Fields.Summary)
+	require.Equal(t, "2", issue.Fields.Description)
+	require.Equal(t, "sre", issue.Fields.Unknowns["customfield_10001"])
+
+	_, _, err = receiver.Close(context.Background(), label, data, "Closed")
+	require.EqualError(t, err, `JIRA state "Closed" does not exist or no transition possible for 1`)
+	key, _, err = receiver.Close(context.Background(), label, data, "Done")
+	require.NoError(t, err)
+	require.Equal(t, "1", key)
+	require.Equal(t, "Done", issue.Fields.Status.StatusCategory.Key)
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/pkg/errors"
+)
+
+// Preview is what a receiver renders from a notification: the issue it would create, and the label it finds the issue
+// of the alert group by.
+type Preview struct {
+	Project     string   `json:"project"`
+	Summary     string   `json:"summary"`
+	Description string   `json:"description"`
+	IssueType   string   `json:"issue_type"`
+	Priority    string   `json:"priority,omitempty"`
+	Components  []string `json:"components,omitempty"`
+	// Labels are the labels of the issue: the group label, followed by the group labels of the alerts if the receiver
+	// sets add_group_labels.
+	Labels     []string `json:"labels"`
+	GroupLabel string   `json:"group_label"`
+	// Fields are the receiver's fields with their templates executed.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Preview renders the templates of the receiver for data the way Notify does, without talking to Jira. The errors of
+// templates that fail to parse or execute wrap a *template.Error.
+func (r *Receiver) Preview(ctx context.Context, data *alertmanager.Data, hashJiraLabel bool) (*Preview, error) {
+	p := &Preview{GroupLabel: toGroupTicketLabel(ctx, data.GroupLabels, hashJiraLabel)}
+	var err error
+	if p.Project, err = r.tmpl.Execute(r.conf.Project, data); err != nil {
+		return nil, errors.Wrap(err, "generate project from template")
+	}
+	if p.Summary, err = r.tmpl.Execute(r.conf.Summary, data); err != nil {
+		return nil, errors.Wrap(err, "generate summary from template")
+	}
+	if p.Description, err = r.tmpl.Execute(r.conf.Description, data); err != nil {
+		return nil, errors.Wrap(err, "render issue description")
+	}
+	issue, err := r.newIssue(ctx, data, p.Project, p.GroupLabel, p.Summary, p.Description)
+	if err != nil {
+		return nil, err
+	}
+	p.IssueType = issue.Fields.Type.Name
+	if issue.Fields.Priority != nil {
+		p.Priority = issue.Fields.Priority.Name
+	}
+	for _, c := range issue.Fields.Components {
+		p.Components = append(p.Components, c.Name)
+	}
+	p.Labels = issue.Fields.Labels
+	if len(r.conf.Fields) > 0 {
+		p.Fields = make(map[string]interface{}, len(r.conf.Fields))
+		for key := range r.conf.Fields {
+			p.Fields[key] = issue.Fields.Unknowns[key]
+		}
+	}
+	return p, nil
+}
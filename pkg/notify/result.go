@@ -0,0 +1,108 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Action is what Notify did about the issue of an alert group.
+type Action string
+
+const (
+	// ActionCreated is a new issue.
+	ActionCreated Action = "created"
+	// ActionUpdated is an open issue whose summary or description changed, or that was commented on.
+	ActionUpdated Action = "updated"
+	// ActionReopened is a resolved issue that was reopened.
+	ActionReopened Action = "reopened"
+	// ActionResolved is an issue resolved because none of its alerts fire anymore.
+	ActionResolved Action = "resolved"
+	// ActionSkippedWontFix is a resolved issue that was not reopened because of its won't fix resolution.
+	ActionSkippedWontFix Action = "skipped-wontfix"
+	// ActionNoop is an issue that was up to date, or a notification that failed before changing anything.
+	ActionNoop Action = "noop"
+)
+
+// NotifyResult describes what Notify did.
+type NotifyResult struct {
+	// IssueKey is the key of the issue of the alert group. It is empty if no issue was found or created.
+	IssueKey string `json:"issue_key"`
+	// IssueURL is the address of the issue in the Jira web interface.
+	IssueURL string `json:"issue_url,omitempty"`
+	Action   Action `json:"action"`
+	// APICalls are the Jira API calls sent, in order, retries included. Changes a receiver in dry-run mode only
+	// planned are not included.
+	APICalls []string `json:"api_calls"`
+}
+
+// setIssue sets the issue the result is about, in the Jira instance of apiURL.
+func (res *NotifyResult) setIssue(apiURL, key string) {
+	res.IssueKey = key
+	res.IssueURL = ""
+	if key != "" {
+		res.IssueURL = strings.TrimSuffix(apiURL, "/") + "/browse/" + key
+	}
+}
+
+// RetryableError is returned by Notify for failures that may not happen again if the notification is retried later,
+// such as Jira being unavailable or throttling.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the failure.
+func (e *RetryableError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the failure.
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable tells whether err is, or wraps, a RetryableError.
+func IsRetryable(err error) bool {
+	var re *RetryableError
+	return errors.As(err, &re)
+}
+
+// retryable returns err as a RetryableError if retry is set.
+func retryable(retry bool, err error) error {
+	if err == nil || !retry {
+		return err
+	}
+	return &RetryableError{Err: err}
+}
+
+type apiCallsKey struct{}
+
+// withAPICalls returns a context under which the Jira API calls sent by do are appended to calls.
+func withAPICalls(ctx context.Context, calls *[]string) context.Context {
+	return context.WithValue(ctx, apiCallsKey{}, calls)
+}
+
+// recordAPICall appends api to the calls of ctx, if it has any.
+func recordAPICall(ctx context.Context, api string) {
+	if calls, ok := ctx.Value(apiCallsKey{}).(*[]string); ok {
+		*calls = append(*calls, api)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+func TestNotify_Result(t *testing.T) {
+	firing := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	resolved := &alertmanager.Data{
+		Status:      alertmanager.AlertResolved,
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	label := toGroupTicketLabel(context.Background(), firing.GroupLabels, true)
+	// withIssue returns a Jira with the issue of the alert group, with the given summary and resolution.
+	withIssue := func(summary, resolution string) func() *fakeJira {
+		return func() *fakeJira {
+			f := newTestFakeJira()
+			f.transitionsByID["5678"] = jira.Transition{ID: "5678", Name: "reopened"}
+			issue, _, err := f.CreateWithContext(context.Background(), &jira.Issue{Fields: &jira.IssueFields{
+				Project:  jira.Project{Key: "abc"},
+				Labels:   []string{label},
+				Summary:  summary,
+				Unknowns: tcontainer.NewMarshalMap(),
+			}})
+			require.NoError(t, err)
+			if resolution != "" {
+				f.issuesByKey[issue.Key].Fields.Status.StatusCategory.Key = "done"
+				f.issuesByKey[issue.Key].Fields.Resolution = &jira.Resolution{Name: resolution}
+			}
+			return f
+		}
+	}
+
+	for _, tcase := range []struct {
+		name        string
+		initJira    func() *fakeJira
+		autoResolve bool
+		data        *alertmanager.Data
+		expected    *NotifyResult
+		expectedErr string
+	}{
+		{
+			name:     "created",
+			initJira: newTestFakeJira,
+			data:     firing,
+			expected: &NotifyResult{
+				IssueKey: "1", IssueURL: "https://jira.example.com/browse/1", Action: ActionCreated,
+				APICalls: []string{"Issue.Search", "Issue.Create"},
+			},
+		},
+		{
+			name:     "noop",
+			initJira: withIssue("[FIRING:1] b ", ""),
+			data:     firing,
+			expected: &NotifyResult{
+				IssueKey: "1", IssueURL: "https://jira.example.com/browse/1", Action: ActionNoop,
+				APICalls: []string{"Issue.Search"},
+			},
+		},
+		{
+			name:     "updated",
+			initJira: withIssue("[FIRING:2] b ", ""),
+			data:     firing,
+			expected: &NotifyResult{
+				IssueKey: "1", IssueURL: "https://jira.example.com/browse/1", Action: ActionUpdated,
+				APICalls: []string{"Issue.Search", "Issue.UpdateWithOptions"},
+			},
+		},
+		{
+			name:        "resolved",
+			initJira:    withIssue("[RESOLVED] b ", ""),
+			autoResolve: true,
+			data:        resolved,
+			expected: &NotifyResult{
+				IssueKey: "1", IssueURL: "https://jira.example.com/browse/1", Action: ActionResolved,
+				APICalls: []string{"Issue.Search", "Issue.GetTransitions", "Issue.DoTransition"},
+			},
+		},
+		{
+			name:     "reopened",
+			initJira: withIssue("[FIRING:1] b ", "Done"),
+			data:     firing,
+			expected: &NotifyResult{
+				IssueKey: "1", IssueURL: "https://jira.example.com/browse/1", Action: ActionReopened,
+				APICalls: []string{"Issue.Search", "Issue.GetTransitions", "Issue.DoTransition"},
+			},
+		},
+		{
+			name:     "skipped won't fix",
+			initJira: withIssue("[FIRING:1] b ", "won't-fix"),
+			data:     firing,
+			expected: &NotifyResult{
+				IssueKey: "1", IssueURL: "https://jira.example.com/browse/1", Action: ActionSkippedWontFix,
+				APICalls: []string{"Issue.Search"},
+			},
+		},
+		{
+			name: "create failed",
+			initJira: func() *fakeJira {
+				f := newTestFakeJira()
+				f.failures["Issue.Create"] = []*jira.Response{testErrResponse(http.StatusServiceUnavailable, "")}
+				return f
+			},
+			data:        firing,
+			expected:    &NotifyResult{Action: ActionNoop, APICalls: []string{"Issue.Search", "Issue.Create"}},
+			expectedErr: "returned status Service Unavailable",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			conf := testReceiverConfig1()
+			conf.APIURL = "https://jira.example.com/"
+			if tcase.autoResolve {
+				conf.AutoResolve = &config.AutoResolve{State: "Done"}
+			}
+			receiver := NewReceiver(conf, template.SimpleTemplate(), tcase.initJira(), NewKeyedMutex())
+			receiver.retry = RetryPolicy{MaxAttempts: 1}
+
+			res, err := receiver.Notify(context.Background(), tcase.data, true)
+			require.Equal(t, tcase.expected, res)
+			if tcase.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tcase.expectedErr)
+			require.True(t, IsRetryable(err))
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	err := retryable(true, errors.New("unavailable"))
+	require.True(t, IsRetryable(err))
+	require.True(t, IsRetryable(errors.Wrap(err, "notify")))
+	require.EqualError(t, err, "unavailable")
+	require.False(t, IsRetryable(retryable(false, errors.New("bad request"))))
+	require.NoError(t, retryable(true, nil))
+}
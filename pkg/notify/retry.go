@@ -0,0 +1,196 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy configures how a Receiver retries Jira requests that failed with a retryable status (429, 500, 502, 503
+// or 504) or without a response. Requests that change Jira are only retried without a response if they never reached
+// it, since Jira may have applied them without answering. Retries never outlast the request context: a retry that could not start before its
+// deadline is not made.
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts per Jira request, including the first one. 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles with every further retry up to MaxBackoff, and
+	// is jittered.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of the receivers created by NewReceiver.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// backoff returns the delay before the given retry.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	return Backoff(p.InitialBackoff, p.MaxBackoff, attempt)
+}
+
+// Backoff returns the delay before the given retry: initial doubled with every attempt, capped at max, with the upper
+// half jittered so that clients retrying against the same Jira do not synchronize.
+func Backoff(initial, max time.Duration, attempt int) time.Duration {
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do calls the Jira API api through fn, retrying retryable failures according to the receiver's retry policy. A
+// Retry-After header in the response is honored if it asks for a longer delay than the backoff. While the circuit
+// breaker of the Jira instance is open, it fails at once with ErrCircuitOpen. Once ctx is done, requests are not sent
+// and the one in progress fails; that is worth retrying, and not held against the Jira instance. It returns whether the
+// last failure is worth retrying later.
+func (r *Receiver) do(ctx context.Context, api string, fn func() (*jira.Response, error)) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return true, errors.Wrapf(err, "JIRA request %s not sent", api)
+		}
+		if r.breaker != nil && !r.breaker.allow() {
+			return true, errors.Wrapf(ErrCircuitOpen, "JIRA request %s to %s not sent", api, r.breaker.instance)
+		}
+		if r.dryRun == nil || isReadOnlyAPI(api) {
+			recordAPICall(ctx, api)
+		}
+		resp, err := fn()
+		if err != nil && ctx.Err() != nil {
+			if r.breaker != nil {
+				r.breaker.cancel()
+			}
+			return true, errors.Wrapf(err, "JIRA request %s cancelled", api)
+		}
+		if r.breaker != nil {
+			r.breaker.record(err != nil && isInstanceFailure(resp))
+		}
+		if err == nil {
+			return false, nil
+		}
+		retry, err := handleJiraErrResponse(ctx, api, resp, err)
+		if !retry || attempt >= r.retry.MaxAttempts {
+			return retry, err
+		}
+		delay := r.retry.backoff(attempt)
+		if d, ok := retryAfter(resp, time.Now()); ok && d > delay {
+			delay = d
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Warnf("not retrying JIRA request, the delay of %s exceeds the deadline api:%s attempt:%d", delay, api, attempt)
+			return retry, err
+		}
+		config.JiraRetries.WithLabelValues(api).Inc()
+		log.Warnf("JIRA request failed, retrying in %s api:%s attempt:%d err:%v", delay, api, attempt, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return retry, err
+		}
+	}
+}
+
+// retryAfter returns the delay asked for by the Retry-After header of resp, given in seconds or as an HTTP date.
+func retryAfter(resp *jira.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || resp.Response == nil {
+		return 0, false
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		log.Debugf("ignoring invalid Retry-After header value:%q", v)
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// isTransportFailure tells whether err, returned by a Jira request without a response, is a failure to connect to Jira
+// or to get its response in time, rather than to build the request or to talk to Jira as configured: URLs with an
+// unsupported scheme and TLS failures do not go away when the request is sent again.
+func isTransportFailure(err error) bool {
+	if isTLSFailure(err) {
+		return false
+	}
+	// *url.Error is a net.Error whatever it wraps.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isNotSent tells whether err, returned by a Jira request without a response, proves that the request never reached
+// Jira: the connection to it could not be established.
+func isNotSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !isTLSFailure(err)
+}
+
+// isTLSFailure tells whether err is a failure to verify the certificate of Jira or to agree on TLS with it.
+func isTLSFailure(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+		opErr            *net.OpError
+	)
+	if errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error") {
+		return true
+	}
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &recordHeader)
+}
+
+// isRetryableStatus tells whether a Jira response with the given status code may succeed when sent again.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// testErrResponse returns a Jira response with the given status code and Retry-After header, if not empty.
+func testErrResponse(code int, retryAfter string) *jira.Response {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &jira.Response{Response: &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    &http.Request{URL: &url.URL{Scheme: "https", Host: "jira.example.com", Path: "/rest/api/2/search"}},
+	}}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tcase := range []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{value: ""},
+		{value: "3", expected: 3 * time.Second, ok: true},
+		{value: "-3"},
+		{value: "Fri, 01 Jan 2021 12:00:30 GMT", expected: 30 * time.Second, ok: true},
+		{value: "Fri, 01 Jan 2021 11:00:00 GMT", expected: 0, ok: true},
+		{value: "soon"},
+	} {
+		d, ok := retryAfter(testErrResponse(http.StatusTooManyRequests, tcase.value), now)
+		require.Equal(t, tcase.ok, ok, tcase.value)
+		require.Equal(t, tcase.expected, d, tcase.value)
+	}
+	_, ok := retryAfter(nil, now)
+	require.False(t, ok)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			d := p.backoff(attempt)
+			require.GreaterOrEqual(t, d, max/2, "attempt %d", attempt)
+			require.LessOrEqual(t, d, max, "attempt %d", attempt)
+		}
+	}
+}
+
+func TestNotify_Retry(t *testing.T) {
+	data := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	for _, tcase := range []struct {
+		name     string
+		failures map[string][]*jira.Response
+		timeout  time.Duration
+
+		expectedRetry   bool
+		expectedErr     string
+		expectedCalls   map[string]int
+		expectedRetries map[string]float64
+		expectedIssues  int
+	}{
+		{
+			name: "rate limited, then created",
+			failures: map[string][]*jira.Response{
+				"Issue.Search": {testErrResponse(http.StatusTooManyRequests, "0")},
+				"Issue.Create": {testErrResponse(http.StatusServiceUnavailable, ""), testErrResponse(http.StatusBadGateway, "")},
+			},
+			expectedCalls:   map[string]int{"Issue.Search": 2, "Issue.Create": 3},
+			expectedRetries: map[string]float64{"Issue.Search": 1, "Issue.Create": 2},
+			expectedIssues:  1,
+		},
+		{
+			name: "attempts exhausted",
+			failures: map[string][]*jira.Response{
+				"Issue.Search": {
+					testErrResponse(http.StatusInternalServerError, ""),
+					testErrResponse(http.StatusInternalServerError, ""),
+					testErrResponse(http.StatusInternalServerError, ""),
+				},
+			},
+			expectedRetry:   true,
+			expectedErr:     "returned status Internal Server Error",
+			expectedCalls:   map[string]int{"Issue.Search": 3},
+			expectedRetries: map[string]float64{"Issue.Search": 2},
+		},
+		{
+			name:            "client error",
+			failures:        map[string][]*jira.Response{"Issue.Search": {testErrResponse(http.StatusBadRequest, "")}},
+			expectedErr:     "returned status Bad Request",
+			expectedCalls:   map[string]int{"Issue.Search": 1},
+			expectedRetries: map[string]float64{},
+		},
+		{
+			name:            "Retry-After beyond the deadline",
+			failures:        map[string][]*jira.Response{"Issue.Search": {testErrResponse(http.StatusTooManyRequests, "60")}},
+			timeout:         time.Second,
+			expectedRetry:   true,
+			expectedErr:     "returned status Too Many Requests",
+			expectedCalls:   map[string]int{"Issue.Search": 1},
+			expectedRetries: map[string]float64{},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			config.JiraRetries.Reset()
+			fakeJira := newTestFakeJira()
+			fakeJira.failures = tcase.failures
+			receiver := NewReceiver(testReceiverConfig1(), template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+			receiver.retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+			ctx := context.Background()
+			if tcase.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tcase.timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			_, err := receiver.Notify(ctx, data, true)
+			require.Less(t, time.Since(start), time.Second)
+			if tcase.expectedErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tcase.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tcase.expectedRetry, IsRetryable(err))
+			for api, calls := range tcase.expectedCalls {
+				require.Equal(t, calls, fakeJira.calls[api], api)
+			}
+			require.Equal(t, len(tcase.expectedRetries), testutil.CollectAndCount(config.JiraRetries))
+			for api, retries := range tcase.expectedRetries {
+				require.Equal(t, retries, testutil.ToFloat64(config.JiraRetries.WithLabelValues(api)), api)
+			}
+			require.Len(t, fakeJira.issuesByKey, tcase.expectedIssues)
+		})
+	}
+}
+
+func TestNotify_Cancel(t *testing.T) {
+	data := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+	for _, tcase := range []struct {
+		name string
+		// ctx returns the context of the notification.
+		ctx func() (context.Context, context.CancelFunc)
+
+		expectedErr error
+	}{
+		{
+			name: "cancelled before",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			expectedErr: context.Canceled,
+		},
+		{
+			name: "deadline during search",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			expectedErr: context.DeadlineExceeded,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			fakeJira.searchDelay = time.Minute
+			conf := testReceiverConfig1()
+			conf.APIURL = "https://cancel.example.com"
+			conf.CircuitBreaker = &config.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: config.Duration(time.Minute)}
+			receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+			ctx, cancel := tcase.ctx()
+			defer cancel()
+			start := time.Now()
+			res, err := receiver.Notify(ctx, data, true)
+			require.Less(t, time.Since(start), time.Second)
+			require.True(t, errors.Is(err, tcase.expectedErr), "%v", err)
+			require.True(t, IsRetryable(err))
+			require.Equal(t, ActionNoop, res.Action)
+			require.Empty(t, fakeJira.calls)
+			require.Empty(t, fakeJira.issuesByKey)
+			// Cancelled requests are not held against the Jira instance.
+			require.Equal(t, map[string]string{"https://cancel.example.com": "closed"}, filterStates(CircuitStates(), "https://cancel.example.com"))
+		})
+	}
+}
+
+func TestNotify_Unreachable(t *testing.T) {
+	// A port nothing listens on anymore.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	apiURL := "http://" + l.Addr().String() + "/"
+	require.NoError(t, l.Close())
+
+	client, err := jira.NewClient(nil, apiURL)
+	require.NoError(t, err)
+	conf := testReceiverConfig1()
+	conf.APIURL = apiURL
+	receiver := NewReceiver(conf, template.SimpleTemplate(), NewIssueService(client), NewKeyedMutex())
+	receiver.retry = RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	config.JiraRetries.Reset()
+
+	res, err := receiver.Notify(context.Background(), &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection refused")
+	require.True(t, IsRetryable(err))
+	require.Equal(t, []string{"Issue.Search", "Issue.Search"}, res.APICalls)
+	require.Equal(t, float64(1), testutil.ToFloat64(config.JiraRetries.WithLabelValues("Issue.Search")))
+}
+
+func TestHandleJiraErrResponse_NoResponse(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "https://jira.example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	dropped := &url.Error{Op: "Post", URL: "https://jira.example.com", Err: io.ErrUnexpectedEOF}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tcase := range []struct {
+		name          string
+		ctx           context.Context
+		api           string
+		err           error
+		expectedRetry bool
+	}{
+		{name: "read refused", api: "Issue.Search", err: refused, expectedRetry: true},
+		{name: "write refused", api: "Issue.Create", err: refused, expectedRetry: true},
+		{name: "read dropped", api: "Issue.Search", err: dropped, expectedRetry: true},
+		{name: "create dropped", api: "Issue.Create", err: dropped},
+		{name: "comment timed out", api: "Issue.AddComment", err: errors.Wrap(context.DeadlineExceeded, "comment")},
+		{name: "read timed out", api: "Issue.Search", err: errors.Wrap(context.DeadlineExceeded, "search"), expectedRetry: true},
+		{
+			name: "unknown certificate authority",
+			api:  "Issue.Search",
+			err:  &url.Error{Op: "Get", URL: "https://jira.example.com", Err: x509.UnknownAuthorityError{}},
+		},
+		{
+			name: "TLS alert",
+			api:  "Issue.Search",
+			err:  &url.Error{Op: "Get", URL: "https://jira.example.com", Err: &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}},
+		},
+		{
+			name: "unsupported scheme",
+			api:  "Issue.Search",
+			err:  &url.Error{Op: "Get", URL: "jira.example.com", Err: errors.New(`unsupported protocol scheme ""`)},
+		},
+		{name: "request not built", api: "Issue.Search", err: errors.New("json: unsupported type")},
+		{name: "write cancelled", ctx: cancelled, api: "Issue.Create", err: dropped, expectedRetry: true},
+		{name: "write cancelled by Jira client", api: "Issue.AddComment", err: errors.Wrap(context.Canceled, "comment"), expectedRetry: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			ctx := tcase.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			retry, err := handleJiraErrResponse(ctx, tcase.api, nil, tcase.err)
+			require.Error(t, err)
+			require.Equal(t, tcase.expectedRetry, retry)
+		})
+	}
+}
+
+func TestNotify_CreateUnanswered(t *testing.T) {
+	data := &alertmanager.Data{
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alertmanager.Alerts{{Status: alertmanager.AlertFiring}},
+		GroupLabels: alertmanager.KV{"a": "b"},
+	}
+
+	for _, tcase := range []struct {
+		name              string
+		unansweredCreates []bool
+		expectedCalls     map[string]int
+		expectedErr       bool
+	}{
+		{
+			name:              "created without answer",
+			unansweredCreates: []bool{true},
+			expectedCalls:     map[string]int{"Issue.Search": 2, "Issue.Create": 1},
+		},
+		{
+			name:              "not created",
+			unansweredCreates: []bool{false},
+			expectedCalls:     map[string]int{"Issue.Search": 2, "Issue.Create": 2},
+		},
+		{
+			name:              "never answered",
+			unansweredCreates: []bool{false, false},
+			expectedCalls:     map[string]int{"Issue.Search": 3, "Issue.Create": 2},
+			expectedErr:       true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			fakeJira.unansweredCreates = tcase.unansweredCreates
+			receiver := NewReceiver(testReceiverConfig1(), template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+			receiver.retry = RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+			res, err := receiver.Notify(context.Background(), data, true)
+			require.Equal(t, tcase.expectedCalls, fakeJira.calls)
+			if tcase.expectedErr {
+				require.Error(t, err)
+				// Notifying again searches for the issue before creating it.
+				require.True(t, IsRetryable(err))
+				require.Empty(t, fakeJira.issuesByKey)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, ActionCreated, res.Action)
+			require.Len(t, fakeJira.issuesByKey, 1)
+			require.Equal(t, "1", res.IssueKey)
+		})
+	}
+}
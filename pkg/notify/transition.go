@@ -0,0 +1,104 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// doTransition brings issue into target, the name of a transition or of the status it leads to, and sends payload,
+// whose transition ID it sets, with the transition that reaches it. Jira only lists the transitions out of the current
+// status, so if none reaches target it walks the workflow one transition at a time, up to the receiver's
+// max_transition_hops, never into a status it has already been in. In dry-run mode nothing moves, so the walk stops
+// after planning its first step.
+func (r *Receiver) doTransition(ctx context.Context, issue *jira.Issue, target string, payload jira.CreateTransitionPayload) (bool, error) {
+	maxHops := r.conf.MaxTransitionHops
+	if maxHops < 1 {
+		maxHops = 1
+	}
+	visited := map[string]bool{}
+	if issue.Fields != nil && issue.Fields.Status != nil && issue.Fields.Status.Name != "" {
+		visited[issue.Fields.Status.Name] = true
+	}
+	for hop := 1; ; hop++ {
+		var transitions []jira.Transition
+		retry, err := r.do(ctx, "Issue.GetTransitions", func() (resp *jira.Response, err error) {
+			transitions, resp, err = r.client.GetTransitionsWithContext(ctx, issue.Key)
+			return resp, err
+		})
+		if err != nil {
+			return retry, err
+		}
+
+		if t, ok := findTransition(transitions, target); ok {
+			log.Debug("msg", "transition", "key", issue.Key, "target", target, "transition", t.Name, "transitionID", t.ID, "hop", hop)
+			payload.Transition.ID = t.ID
+			return r.do(ctx, "Issue.DoTransition", func() (*jira.Response, error) {
+				return r.client.DoTransitionWithPayloadWithContext(ctx, issue.Key, payload)
+			})
+		}
+		if hop >= maxHops {
+			if maxHops == 1 {
+				return false, errors.Errorf("JIRA state %q does not exist or no transition possible for %s", target, issue.Key)
+			}
+			return false, errors.Errorf("JIRA state %q not reachable within %d transitions for %s", target, maxHops, issue.Key)
+		}
+
+		t, ok := nextTransition(transitions, visited)
+		if !ok {
+			return false, errors.Errorf("JIRA state %q not reachable for %s, no transition into a status not visited yet after %d transitions", target, issue.Key, hop-1)
+		}
+		log.Debug("msg", "transition towards target", "key", issue.Key, "target", target, "transition", t.Name, "status", t.To.Name, "hop", hop)
+		retry, err = r.do(ctx, "Issue.DoTransition", func() (*jira.Response, error) {
+			return r.client.DoTransitionWithPayloadWithContext(ctx, issue.Key, jira.CreateTransitionPayload{Transition: jira.TransitionPayload{ID: t.ID}})
+		})
+		if err != nil {
+			return retry, err
+		}
+		if r.dryRun != nil {
+			log.Infof("dry run, not planning the transitions after the first one key:%s target:%s", issue.Key, target)
+			return false, nil
+		}
+		visited[t.To.Name] = true
+	}
+}
+
+// findTransition returns the transition named target, or else the first one into the status named target.
+func findTransition(transitions []jira.Transition, target string) (jira.Transition, bool) {
+	for _, t := range transitions {
+		if t.Name == target {
+			return t, true
+		}
+	}
+	for _, t := range transitions {
+		if t.To.Name == target {
+			return t, true
+		}
+	}
+	return jira.Transition{}, false
+}
+
+// nextTransition returns the first transition into a status that is not visited.
+func nextTransition(transitions []jira.Transition, visited map[string]bool) (jira.Transition, bool) {
+	for _, t := range transitions {
+		if t.To.Name != "" && !visited[t.To.Name] {
+			return t, true
+		}
+	}
+	return jira.Transition{}, false
+}
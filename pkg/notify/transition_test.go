@@ -0,0 +1,116 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/require"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+func TestDoTransition(t *testing.T) {
+	done := jira.Status{Name: "Done", StatusCategory: jira.StatusCategory{Key: "done"}}
+	verify := jira.Status{Name: "Verify", StatusCategory: jira.StatusCategory{Key: "indeterminate"}}
+	toDo := jira.Status{Name: "To Do", StatusCategory: jira.StatusCategory{Key: "new"}}
+	statuses := map[string]jira.Status{"Done": done, "Verify": verify, "To Do": toDo}
+	// Reopening a done issue has to go through Verify.
+	workflow := map[string][]jira.Transition{
+		"Done":   {{ID: "1", Name: "Verify", To: verify}},
+		"Verify": {{ID: "2", Name: "Reject", To: done}, {ID: "3", Name: "Reopen", To: toDo}},
+		"To Do":  {{ID: "4", Name: "Finish", To: done}},
+	}
+	payload := jira.CreateTransitionPayload{Update: jira.TransitionPayloadUpdate{Comment: []jira.TransitionPayloadComment{{
+		Add: jira.TransitionPayloadCommentBody{Body: "moved"},
+	}}}}
+
+	for _, tcase := range []struct {
+		name                string
+		from                string
+		target              string
+		maxHops             int
+		dryRun              bool
+		expectedStatus      string
+		expectedTransitions int
+		expectedErr         string
+	}{
+		{name: "by transition name", from: "To Do", target: "Finish", expectedStatus: "Done", expectedTransitions: 1},
+		{name: "by status name", from: "To Do", target: "Done", expectedStatus: "Done", expectedTransitions: 1},
+		{
+			name: "two hops away", from: "Done", target: "To Do", maxHops: 3,
+			expectedStatus: "To Do", expectedTransitions: 2,
+		},
+		{
+			name: "two hops away, direct only", from: "Done", target: "To Do",
+			expectedStatus: "Done", expectedErr: `JIRA state "To Do" does not exist or no transition possible for 1`,
+		},
+		{
+			name: "hop limit", from: "Done", target: "Closed", maxHops: 2,
+			expectedStatus: "Verify", expectedTransitions: 1, expectedErr: `JIRA state "Closed" not reachable within 2 transitions for 1`,
+		},
+		{
+			name: "no cycles", from: "Done", target: "Closed", maxHops: 10,
+			expectedStatus: "To Do", expectedTransitions: 2,
+			expectedErr: `JIRA state "Closed" not reachable for 1, no transition into a status not visited yet after 2 transitions`,
+		},
+		{
+			name: "dry run", from: "Done", target: "To Do", maxHops: 3, dryRun: true,
+			expectedStatus: "Done", expectedTransitions: 0,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			fakeJira := newTestFakeJira()
+			fakeJira.workflow = workflow
+			issue, _, err := fakeJira.CreateWithContext(context.Background(), &jira.Issue{Fields: &jira.IssueFields{
+				Project:  jira.Project{Key: "abc"},
+				Type:     jira.IssueType{Name: "Bug"},
+				Labels:   []string{"ALERT{a=\"b\"}"},
+				Unknowns: tcontainer.NewMarshalMap(),
+			}})
+			require.NoError(t, err)
+			start := statuses[tcase.from]
+			fakeJira.issuesByKey[issue.Key].Fields.Status = &start
+			fakeJira.calls = map[string]int{}
+			conf := testReceiverConfig1()
+			conf.MaxTransitionHops = tcase.maxHops
+			conf.DryRun = tcase.dryRun
+			receiver := NewReceiver(conf, template.SimpleTemplate(), fakeJira, NewKeyedMutex())
+
+			_, err = receiver.doTransition(context.Background(), &jira.Issue{Key: issue.Key, Fields: &jira.IssueFields{
+				Project: jira.Project{Key: "abc"},
+				Type:    jira.IssueType{Name: "Bug"},
+				Status:  &start,
+			}}, tcase.target, payload)
+			// The transitions are looked up on the issue itself, the only one there is.
+			require.Len(t, fakeJira.issuesByKey, 1)
+			require.Equal(t, tcase.expectedStatus, fakeJira.issuesByKey[issue.Key].Fields.Status.Name)
+			require.Equal(t, tcase.expectedTransitions, fakeJira.calls["Issue.DoTransition"])
+			if tcase.expectedErr != "" {
+				require.EqualError(t, err, tcase.expectedErr)
+				require.Empty(t, fakeJira.commentsByKey[issue.Key])
+				return
+			}
+			require.NoError(t, err)
+			if tcase.dryRun {
+				// Nothing moves, so only the first transition is planned.
+				require.Len(t, receiver.PlannedActions(), 1)
+				return
+			}
+			// The payload only goes with the last transition.
+			require.Equal(t, []string{"moved"}, fakeJira.commentsByKey[issue.Key])
+		})
+	}
+}
@@ -16,8 +16,10 @@ package template
 import (
 	"bytes"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
+	"text/template/parse"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -77,14 +79,123 @@ func (t *Template) Execute(text string, data interface{}) (string, error) {
 	}
 	tmpl, err = tmpl.New("").Parse(text)
 	if err != nil {
-		return "", errors.Wrapf(err, "parse template %s", text)
+		return "", errors.Wrapf(newError(text, err), "parse template %s", text)
 	}
 	var buf bytes.Buffer
 
 	if err = tmpl.Execute(&buf, data); err != nil {
-		return "", errors.Wrapf(err, "execute template %s", text)
+		return "", errors.Wrapf(newError(text, err), "execute template %s", text)
 	}
 	ret := buf.String()
 	log.Debug("msg", "template output", "output", ret)
 	return ret, nil
 }
+
+// Validate parses the provided text against the templates defined in t.tmpl without executing it, so that syntax
+// errors and references to undefined templates are caught before any alert is processed.
+func (t *Template) Validate(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return errors.Wrap(err, "parse clone tmpl")
+	}
+	tmpl, err = tmpl.New("").Parse(text)
+	if err != nil {
+		return errors.Wrapf(newError(text, err), "parse template %s", text)
+	}
+	if name := undefinedTemplate(tmpl, tmpl.Tree.Root); name != "" {
+		return errors.Errorf("template %s references undefined template %q", text, name)
+	}
+	return nil
+}
+
+// undefinedTemplate walks the parse tree and returns the name of the first {{ template }} invocation that does not
+// resolve to a defined template, or an empty string if all of them do.
+func undefinedTemplate(tmpl *template.Template, node parse.Node) string {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		if tmpl.Lookup(n.Name) == nil {
+			return n.Name
+		}
+	case *parse.ListNode:
+		if n == nil {
+			return ""
+		}
+		for _, c := range n.Nodes {
+			if name := undefinedTemplate(tmpl, c); name != "" {
+				return name
+			}
+		}
+	case *parse.IfNode:
+		return firstNonEmpty(undefinedTemplate(tmpl, n.List), undefinedTemplate(tmpl, n.ElseList))
+	case *parse.RangeNode:
+		return firstNonEmpty(undefinedTemplate(tmpl, n.List), undefinedTemplate(tmpl, n.ElseList))
+	case *parse.WithNode:
+		return firstNonEmpty(undefinedTemplate(tmpl, n.List), undefinedTemplate(tmpl, n.ElseList))
+	}
+	return ""
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Error is an error parsing or executing a template, with the position it occurred at.
+type Error struct {
+	// Text is the template text that was parsed or executed.
+	Text string
+	// Name is the name of the defined template the error occurred in, typically the template file, or empty if it
+	// occurred in Text itself.
+	Name string
+	// Line is the line of the error, starting at 1, or 0 if it is not known.
+	Line int
+	// Column is the column of the error, starting at 1, or 0 if it is not known. text/template only reports the column
+	// of execution errors.
+	Column int
+	// Message is the error without its position.
+	Message string
+
+	err error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the error returned by text/template.
+func (e *Error) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the error returned by text/template.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// errPosition matches the position text/template prefixes its errors with: the template name, the line and, for
+// execution errors, the byte offset in the line.
+var errPosition = regexp.MustCompile(`^template: (.*?):(\d+):(?:(\d+):)? (.*)$`)
+
+// newError returns err, as returned by text/template for text, with its position.
+func newError(text string, err error) *Error {
+	e := &Error{Text: text, Message: err.Error(), err: err}
+	m := errPosition.FindStringSubmatch(err.Error())
+	if m == nil {
+		return e
+	}
+	e.Name, e.Message = m[1], m[4]
+	e.Line, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		// text/template counts the column from 0.
+		col, _ := strconv.Atoi(m[3])
+		e.Column = col + 1
+	}
+	return e
+}
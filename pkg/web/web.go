@@ -0,0 +1,222 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package web configures TLS termination for JIRAlert's HTTP server from a web configuration file, in the format of
+// the tls_server_config of Prometheus' --web.config.file.
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the web configuration file.
+type Config struct {
+	TLSServerConfig *TLSServerConfig `yaml:"tls_server_config"`
+}
+
+// TLSServerConfig configures the TLS connections to JIRAlert.
+type TLSServerConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile holds the CAs client certificates are verified against.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuthType is the name of a tls.ClientAuthType. It defaults to RequireAndVerifyClientCert if ClientCAFile is
+	// set and to NoClientCert otherwise.
+	ClientAuthType string `yaml:"client_auth_type"`
+	// MinVersion is one of TLS10, TLS11, TLS12 and TLS13. Optional (default: TLS12).
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites are names of the crypto/tls cipher suites allowed up to TLS 1.2. Optional (default: Go's).
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// LoadFile parses the web configuration file and resolves the paths in it against the file's directory.
+func LoadFile(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, errors.Wrapf(err, "parse web config %s", filename)
+	}
+	if cfg.TLSServerConfig == nil {
+		return nil, errors.Errorf("missing tls_server_config in web config %s", filename)
+	}
+	tc := cfg.TLSServerConfig
+	dir := filepath.Dir(filename)
+	for _, path := range []*string{&tc.CertFile, &tc.KeyFile, &tc.ClientCAFile} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	return cfg, nil
+}
+
+// NewTLSConfig returns the server TLS configuration described by tc. The certificate, key and client CA files are
+// loaded once here, so that errors surface at startup, and are loaded again in handshakes after they changed on disk.
+// If reloading fails, the files loaded last keep being used.
+func NewTLSConfig(tc *TLSServerConfig) (*tls.Config, error) {
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file must both be set")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tc.MinVersion != "" {
+		v, ok := tlsVersions[tc.MinVersion]
+		if !ok {
+			return nil, errors.Errorf("unknown min_version %q", tc.MinVersion)
+		}
+		tlsConfig.MinVersion = v
+	}
+	if len(tc.CipherSuites) > 0 {
+		ids := make(map[string]uint16, len(tls.CipherSuites()))
+		for _, cs := range tls.CipherSuites() {
+			ids[cs.Name] = cs.ID
+		}
+		for _, name := range tc.CipherSuites {
+			id, ok := ids[name]
+			if !ok {
+				return nil, errors.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+	switch {
+	case tc.ClientAuthType != "":
+		ca, ok := clientAuthTypes[tc.ClientAuthType]
+		if !ok {
+			return nil, errors.Errorf("unknown client_auth_type %q", tc.ClientAuthType)
+		}
+		tlsConfig.ClientAuth = ca
+	case tc.ClientCAFile != "":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if tc.ClientCAFile == "" && (tlsConfig.ClientAuth == tls.VerifyClientCertIfGiven || tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, errors.Errorf("client_auth_type %s requires client_ca_file", tc.ClientAuthType)
+	}
+
+	kp := &keyPair{conf: tc}
+	if err := kp.reload(); err != nil {
+		return nil, err
+	}
+	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := kp.current()
+		return cert, nil
+	}
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, clientCAs := kp.current()
+		c := tlsConfig.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = []tls.Certificate{*cert}
+		c.ClientCAs = clientCAs
+		c.NextProtos = []string{"h2", "http/1.1"}
+		return c, nil
+	}
+	return tlsConfig, nil
+}
+
+// keyPair holds the server certificate and client CAs loaded from the files of conf.
+type keyPair struct {
+	conf *TLSServerConfig
+
+	mtx       sync.Mutex
+	stamp     string
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// current returns the certificate and client CAs, loading them first if the files changed since they were last loaded.
+func (kp *keyPair) current() (*tls.Certificate, *x509.CertPool) {
+	kp.mtx.Lock()
+	changed := kp.stamp != kp.fileStamp()
+	kp.mtx.Unlock()
+	if changed {
+		if err := kp.reload(); err != nil {
+			log.Errorf("failed to reload TLS certificate, keeping the previous one cert_file:%s err:%v", kp.conf.CertFile, err)
+		} else {
+			log.Infof("reloaded TLS certificate cert_file:%s", kp.conf.CertFile)
+		}
+	}
+	kp.mtx.Lock()
+	defer kp.mtx.Unlock()
+	return kp.cert, kp.clientCAs
+}
+
+func (kp *keyPair) reload() error {
+	kp.mtx.Lock()
+	defer kp.mtx.Unlock()
+	// Taken before reading, so that a change while reading is picked up by the next handshake.
+	stamp := kp.fileStamp()
+	cert, err := tls.LoadX509KeyPair(kp.conf.CertFile, kp.conf.KeyFile)
+	if err != nil {
+		kp.stamp = stamp
+		return errors.Wrap(err, "load server certificate")
+	}
+	var clientCAs *x509.CertPool
+	if kp.conf.ClientCAFile != "" {
+		ca, err := os.ReadFile(kp.conf.ClientCAFile)
+		if err != nil {
+			kp.stamp = stamp
+			return errors.Wrap(err, "read client_ca_file")
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(ca) {
+			kp.stamp = stamp
+			return errors.Errorf("no PEM certificates found in client_ca_file %s", kp.conf.ClientCAFile)
+		}
+	}
+	kp.stamp, kp.cert, kp.clientCAs = stamp, &cert, clientCAs
+	return nil
+}
+
+// fileStamp identifies the current version of the files by their size and modification time. It follows symlinks, as
+// used for Kubernetes secrets.
+func (kp *keyPair) fileStamp() string {
+	var b strings.Builder
+	for _, path := range []string{kp.conf.CertFile, kp.conf.KeyFile, kp.conf.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%d/%d;", fi.Size(), fi.ModTime().UnixNano())
+		} else {
+			b.WriteString("-;")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "jiralert test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate with the given serial number and its key, PEM encoded.
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "jiralert"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web.yml")
+	writeFile(t, path, []byte(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: /etc/jiralert/server.key
+  client_ca_file: ca.crt
+  min_version: TLS13
+`))
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, &TLSServerConfig{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      "/etc/jiralert/server.key",
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+		MinVersion:   "TLS13",
+	}, cfg.TLSServerConfig)
+
+	for _, tcase := range []struct {
+		content string
+		err     string
+	}{
+		{content: "", err: "missing tls_server_config"},
+		{content: "tls_server_config: {cert_file: a, key_file: b, ciphers: []}", err: "field ciphers not found"},
+	} {
+		writeFile(t, path, []byte(tcase.content))
+		_, err := LoadFile(path)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tcase.err)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	tlsConfig, err := NewTLSConfig(&TLSServerConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		MinVersion:   "TLS12",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+
+	for _, tcase := range []struct {
+		conf *TLSServerConfig
+		err  string
+	}{
+		{conf: &TLSServerConfig{CertFile: certFile}, err: "cert_file and key_file must both be set"},
+		{conf: &TLSServerConfig{CertFile: certFile, KeyFile: caFile}, err: "load server certificate"},
+		{conf: &TLSServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "SSL3"}, err: `unknown min_version "SSL3"`},
+		{conf: &TLSServerConfig{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, err: "unknown or insecure cipher suite"},
+		{conf: &TLSServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "Always"}, err: `unknown client_auth_type "Always"`},
+		{conf: &TLSServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "RequireAndVerifyClientCert"}, err: "requires client_ca_file"},
+		{conf: &TLSServerConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile + ".missing"}, err: "read client_ca_file"},
+	} {
+		_, err := NewTLSConfig(tcase.conf)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tcase.err)
+	}
+}
+
+func TestNewTLSConfig_MutualTLSAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	tlsConfig, err := NewTLSConfig(&TLSServerConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCertPEM, clientKeyPEM := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+	// get does a request on a new connection and returns the serial number of the server certificate.
+	get := func(certs ...tls.Certificate) (int64, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return 0, err
+		}
+		_ = resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	_, err = get()
+	require.Error(t, err, "clients without a certificate are rejected")
+	serial, err := get(clientCert)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), serial)
+
+	// A rotated certificate is served by the next handshake.
+	certPEM, keyPEM = ca.issue(t, 4, x509.ExtKeyUsageServerAuth)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, certFile, certPEM)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	serial, err = get(clientCert)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), serial)
+
+	// A broken rotation keeps the previous certificate in use.
+	writeFile(t, certFile, []byte("not a certificate"))
+	serial, err = get(clientCert)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), serial)
+
+	// Rotated client CAs are picked up as well.
+	otherCA := newTestCA(t)
+	writeFile(t, caFile, otherCA.pem)
+	writeFile(t, certFile, certPEM)
+	require.NoError(t, os.Chtimes(caFile, future.Add(time.Minute), future.Add(time.Minute)))
+	_, err = get(clientCert)
+	require.Error(t, err, "client certificates of the previous CA are rejected")
+}
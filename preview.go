@@ -0,0 +1,97 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// PreviewHandlerFunc is the HTTP handler for `POST /api/v1/preview?receiver=NAME`. It renders the templates of the
+// receiver for the Alertmanager webhook payload in the body, the way a notification would, and answers with the
+// rendered issue without talking to Jira. The receiver defaults to the payload's receiver. Templates that fail to parse
+// or execute are answered with 422 and the position of the error.
+func (wh *Webhook) PreviewHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer func() { _ = req.Body.Close() }()
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = w.Write([]byte("only POST allowed"))
+			return
+		}
+		data := &alertmanager.Data{}
+		if err := jsoniter.NewDecoder(req.Body).Decode(data); err != nil {
+			errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to parse request body: %v", err))
+			return
+		}
+		if receiver := req.URL.Query().Get("receiver"); receiver != "" {
+			data.Receiver = receiver
+		}
+		if data.Receiver == "" {
+			errorHandler(w, http.StatusBadRequest, errors.New("missing receiver, pass it as ?receiver= or in the payload"))
+			return
+		}
+		snap := wh.reloader.Snapshot()
+		rc, err := snap.Config.ResolveReceiver(req.Context(), data.Receiver)
+		if err != nil {
+			errorHandler(w, http.StatusBadRequest, err)
+			return
+		}
+		preview, err := notify.NewReceiver(rc, snap.Template, nil, nil).Preview(req.Context(), data, wh.hashJiraLabel)
+		if err != nil {
+			var terr *template.Error
+			if errors.As(err, &terr) {
+				templateErrorHandler(w, err, terr)
+				return
+			}
+			errorHandler(w, http.StatusInternalServerError, err)
+			return
+		}
+		wb, _ := jsoniter.Marshal(preview)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(wb)
+	}
+}
+
+// templateErrorHandler answers with err, caused by the template error terr, and the position of terr.
+func templateErrorHandler(w http.ResponseWriter, err error, terr *template.Error) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	response := struct {
+		Error    bool
+		Status   int
+		Message  string
+		Template string
+		Name     string `json:",omitempty"`
+		Line     int
+		Column   int
+	}{
+		Error:    true,
+		Status:   http.StatusUnprocessableEntity,
+		Message:  err.Error(),
+		Template: terr.Text,
+		Name:     terr.Name,
+		Line:     terr.Line,
+		Column:   terr.Column,
+	}
+	wb, _ := jsoniter.Marshal(response)
+	_, _ = w.Write(wb)
+	log.Warnf("failed to render template line:%d column:%d err:%v", terr.Line, terr.Column, err)
+}
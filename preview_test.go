@@ -0,0 +1,90 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewHandlerFunc(t *testing.T) {
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config(`
+  - name: 'jira-xy'
+    project: XY
+    priority: '{{ if eq .CommonLabels.severity "critical" }}High{{ else }}Low{{ end }}'
+    components: ['{{ .CommonLabels.team }}']
+    add_group_labels: true
+    fields:
+      customfield_10001: '{{ .CommonLabels.team }}'
+      customfield_10002: {value: '{{ .CommonLabels.severity }}'}
+  - name: 'jira-broken'
+    project: XY
+    fields:
+      customfield_10001: "{{ .CommonLabels.team }}\n{{ .CommonLabels.team.name }}"
+`)))
+	require.NoError(t, err)
+	h := NewWebhook(reloader, false, nil, nil, nil).PreviewHandlerFunc()
+
+	payload := `{"receiver":"jira-ab","status":"firing","groupLabels":{"alertname":"A"},` +
+		`"commonLabels":{"alertname":"A","severity":"critical","team":"sre"},` +
+		`"alerts":[{"status":"firing","labels":{"alertname":"A","severity":"critical","team":"sre"}}]}`
+	for _, tcase := range []struct {
+		name   string
+		method string
+		query  string
+		body   string
+		code   int
+		resp   string
+	}{
+		{name: "method", method: http.MethodGet, query: "?receiver=jira-xy", body: payload, code: http.StatusMethodNotAllowed},
+		{name: "invalid payload", query: "?receiver=jira-xy", body: "{", code: http.StatusBadRequest},
+		{name: "unknown receiver", query: "?receiver=jira-nope", body: payload, code: http.StatusBadRequest},
+		{
+			name: "receiver of the payload", body: payload, code: http.StatusOK,
+			resp: `{"project":"AB","summary":"[FIRING:1] A (critical sre)","issue_type":"Bug",` +
+				`"description":"Labels:\n - alertname = A\n - severity = critical\n - team = sre\n\nAnnotations:\n\nSource: \n",` +
+				`"labels":["ALERT{alertname=\"A\"}"],"group_label":"ALERT{alertname=\"A\"}"}`,
+		},
+		{
+			name: "receiver of the query", query: "?receiver=jira-xy", body: payload, code: http.StatusOK,
+			resp: `{"project":"XY","summary":"[FIRING:1] A (critical sre)","issue_type":"Bug","priority":"High",` +
+				`"description":"Labels:\n - alertname = A\n - severity = critical\n - team = sre\n\nAnnotations:\n\nSource: \n",` +
+				`"components":["sre"],"labels":["ALERT{alertname=\"A\"}","alertname=\"A\""],"group_label":"ALERT{alertname=\"A\"}",` +
+				`"fields":{"customfield_10001":"sre","customfield_10002":{"value":"critical"}}}`,
+		},
+		{
+			name: "template error", query: "?receiver=jira-broken", body: payload, code: http.StatusUnprocessableEntity,
+			resp: `{"Error":true,"Status":422,` +
+				`"Message":"render field customfield_10001: execute template {{ .CommonLabels.team }}\n{{ .CommonLabels.team.name }}: template: :2:16: executing \"\" at <.CommonLabels.team.name>: can't evaluate field name in type string",` +
+				`"Template":"{{ .CommonLabels.team }}\n{{ .CommonLabels.team.name }}","Line":2,"Column":17}`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			if tcase.method == "" {
+				tcase.method = http.MethodPost
+			}
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(tcase.method, "/api/v1/preview"+tcase.query, strings.NewReader(tcase.body)))
+			require.Equal(t, tcase.code, rec.Code, rec.Body.String())
+			if tcase.resp != "" {
+				require.JSONEq(t, tcase.resp, rec.Body.String())
+			}
+		})
+	}
+	require.Empty(t, jira.Created())
+}
@@ -0,0 +1,322 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// FullPolicy decides what happens to a new notification when the queue is at capacity.
+type FullPolicy string
+
+const (
+	// FullPolicyReject refuses the new notification; the webhook is answered with 503 so Alertmanager retries it.
+	FullPolicyReject FullPolicy = "reject"
+	// FullPolicyDropOldest evicts the oldest queued notification to make room for the new one. Alertmanager sends
+	// the full state of a group on every notification, so the newer one supersedes older ones for the same group.
+	FullPolicyDropOldest FullPolicy = "drop-oldest"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when the queue is at capacity and the policy is FullPolicyReject.
+	ErrQueueFull = errors.New("notification queue is full")
+	// ErrQueueClosed is returned by Enqueue once the queue has been closed for shutdown.
+	ErrQueueClosed = errors.New("notification queue is closed")
+)
+
+// QueueOptions configures the asynchronous notification queue.
+type QueueOptions struct {
+	Capacity   int
+	Workers    int
+	FullPolicy FullPolicy
+	// MaxAttempts bounds the attempts per notification. Each attempt retries the Jira requests it sends according to
+	// notify.DefaultRetryPolicy, so a notification sends up to MaxAttempts times that many of each request.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	NotifyTimeout  time.Duration
+}
+
+// Validate checks the options for values the queue cannot work with.
+func (o QueueOptions) Validate() error {
+	switch {
+	case o.Capacity <= 0:
+		return fmt.Errorf("queue capacity must be positive, got %d", o.Capacity)
+	case o.Workers <= 0:
+		return fmt.Errorf("queue workers must be positive, got %d", o.Workers)
+	case o.FullPolicy != FullPolicyReject && o.FullPolicy != FullPolicyDropOldest:
+		return fmt.Errorf("invalid queue full policy %q, must be one of %s, %s", o.FullPolicy, FullPolicyReject, FullPolicyDropOldest)
+	case o.MaxAttempts <= 0:
+		return fmt.Errorf("queue max attempts must be positive, got %d", o.MaxAttempts)
+	case o.InitialBackoff <= 0 || o.MaxBackoff < o.InitialBackoff:
+		return fmt.Errorf("queue backoff must satisfy 0 < initial (%s) <= max (%s)", o.InitialBackoff, o.MaxBackoff)
+	case o.NotifyTimeout <= 0:
+		return fmt.Errorf("queue notify timeout must be positive, got %s", o.NotifyTimeout)
+	}
+	return nil
+}
+
+// NotifyFunc delivers one notification to Jira. It reports whether a failure is worth retrying.
+type NotifyFunc func(ctx context.Context, data *alertmanager.Data) (retry bool, err error)
+
+type queuedNotification struct {
+	data     *alertmanager.Data
+	notify   NotifyFunc
+	enqueued time.Time
+	// group identifies the alert group, or is empty if Alertmanager did not send a group key.
+	group string
+	// superseded is closed once a newer notification of the group is queued while this one is processed.
+	superseded       chan struct{}
+	supersededClosed bool
+}
+
+// queueGroup identifies the alert group of data, or is empty if Alertmanager did not send a group key.
+func queueGroup(data *alertmanager.Data) string {
+	if data.GroupKey == "" {
+		return ""
+	}
+	return data.Receiver + "\x00" + data.GroupKey
+}
+
+// Queue is a bounded in-memory queue of notifications, processed by a pool of workers that retry with exponential
+// backoff and jitter. The notifications of an alert group are processed one at a time, in order: since Alertmanager
+// sends the full state of the group every time, a newer notification replaces a queued one of the same group, and
+// stops the retries of one being processed.
+type Queue struct {
+	opts QueueOptions
+
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	pending []*queuedNotification
+	// inflight holds the notifications being processed, by alert group.
+	inflight map[string]*queuedNotification
+	closed   bool
+
+	workers sync.WaitGroup
+	// abortCtx is cancelled when Close runs out of time, interrupting notifications and backoffs.
+	abortCtx context.Context
+	abort    context.CancelFunc
+
+	timeNow func() time.Time
+}
+
+// NewQueue creates a queue and starts its workers.
+func NewQueue(opts QueueOptions) (*Queue, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	q := &Queue{opts: opts, inflight: map[string]*queuedNotification{}, timeNow: time.Now}
+	q.cond = sync.NewCond(&q.mtx)
+	q.abortCtx, q.abort = context.WithCancel(context.Background())
+	q.workers.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go q.work()
+	}
+	return q, nil
+}
+
+// Enqueue adds a notification to the queue. notify is called by a worker, possibly several times, unless a newer
+// notification of the same alert group supersedes it first.
+func (q *Queue) Enqueue(data *alertmanager.Data, notify NotifyFunc) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	group := queueGroup(data)
+	if group != "" {
+		if n, ok := q.inflight[group]; ok && !n.supersededClosed {
+			close(n.superseded)
+			n.supersededClosed = true
+		}
+		for _, n := range q.pending {
+			if n.group == group {
+				log.Debugf("replacing queued notification with a newer one receiver:%s groupKey:%s", data.Receiver, data.GroupKey)
+				config.QueueDropped.WithLabelValues("superseded").Inc()
+				n.data, n.notify = data, notify
+				return nil
+			}
+		}
+	}
+	if len(q.pending) >= q.opts.Capacity {
+		if q.opts.FullPolicy == FullPolicyReject {
+			config.QueueDropped.WithLabelValues("full").Inc()
+			return ErrQueueFull
+		}
+		evicted := q.pending[0]
+		q.pending = q.pending[1:]
+		config.QueueDropped.WithLabelValues("evicted").Inc()
+		log.Warnf("notification queue full, dropping oldest notification receiver:%s groupKey:%s", evicted.data.Receiver, evicted.data.GroupKey)
+	}
+	q.pending = append(q.pending, &queuedNotification{
+		data: data, notify: notify, enqueued: q.timeNow(), group: group, superseded: make(chan struct{}),
+	})
+	q.updateMetrics()
+	q.cond.Signal()
+	return nil
+}
+
+// Len returns the number of notifications waiting for a worker.
+func (q *Queue) Len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.pending)
+}
+
+// Close stops accepting notifications and waits for the workers to process everything already queued. If ctx is done
+// first, in-flight notifications are cancelled, the remaining ones are dropped and ctx.Err() is returned.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mtx.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.abort()
+		q.mtx.Lock()
+		q.cond.Broadcast()
+		q.mtx.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// next blocks until a notification whose alert group is not being processed is available, or returns nil once the
+// queue is closed and drained (or aborted).
+func (q *Queue) next() *queuedNotification {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for {
+		if q.abortCtx.Err() != nil || (q.closed && len(q.pending) == 0) {
+			return nil
+		}
+		for i, n := range q.pending {
+			if _, busy := q.inflight[n.group]; n.group != "" && busy {
+				continue
+			}
+			copy(q.pending[i:], q.pending[i+1:])
+			q.pending[len(q.pending)-1] = nil
+			q.pending = q.pending[:len(q.pending)-1]
+			if n.group != "" {
+				q.inflight[n.group] = n
+			}
+			q.updateMetrics()
+			return n
+		}
+		q.cond.Wait()
+	}
+}
+
+// done releases the alert group of n once it has been processed.
+func (q *Queue) done(n *queuedNotification) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if n.group != "" && q.inflight[n.group] == n {
+		delete(q.inflight, n.group)
+	}
+	q.cond.Broadcast()
+}
+
+// updateMetrics must be called with q.mtx held.
+func (q *Queue) updateMetrics() {
+	config.QueueDepth.Set(float64(len(q.pending)))
+	if len(q.pending) == 0 {
+		config.QueueOldestEnqueuedTime.Set(0)
+		return
+	}
+	config.QueueOldestEnqueuedTime.Set(float64(q.pending[0].enqueued.UnixNano()) / 1e9)
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	for {
+		n := q.next()
+		if n == nil {
+			q.dropRemaining()
+			return
+		}
+		config.QueueWaitSeconds.Observe(q.timeNow().Sub(n.enqueued).Seconds())
+		q.process(n)
+		q.done(n)
+	}
+}
+
+// dropRemaining accounts for notifications left behind when Close was aborted.
+func (q *Queue) dropRemaining() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.pending) > 0 {
+		config.QueueDropped.WithLabelValues("shutdown").Add(float64(len(q.pending)))
+		log.Errorf("dropping %d queued notifications on shutdown", len(q.pending))
+		q.pending = nil
+		q.updateMetrics()
+	}
+}
+
+func (q *Queue) process(n *queuedNotification) {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(q.abortCtx, q.opts.NotifyTimeout)
+		retry, err := n.notify(ctx, n.data)
+		cancel()
+		if err == nil {
+			return
+		}
+		if !retry {
+			config.QueueDropped.WithLabelValues("failed").Inc()
+			log.Errorf("notification failed receiver:%s groupKey:%s err:%v", n.data.Receiver, n.data.GroupKey, err)
+			return
+		}
+		if attempt >= q.opts.MaxAttempts {
+			config.QueueDropped.WithLabelValues("retries_exhausted").Inc()
+			log.Errorf("notification failed after %d attempts receiver:%s groupKey:%s err:%v", attempt, n.data.Receiver, n.data.GroupKey, err)
+			return
+		}
+		backoff := q.backoff(attempt)
+		log.Warnf("notification failed, retrying in %s attempt:%d receiver:%s err:%v", backoff, attempt, n.data.Receiver, err)
+		config.QueueRetries.Inc()
+		select {
+		case <-time.After(backoff):
+		case <-n.superseded:
+			config.QueueDropped.WithLabelValues("superseded").Inc()
+			log.Infof("not retrying notification superseded by a newer one receiver:%s groupKey:%s", n.data.Receiver, n.data.GroupKey)
+			return
+		case <-q.abortCtx.Done():
+			config.QueueDropped.WithLabelValues("shutdown").Inc()
+			return
+		}
+	}
+}
+
+// backoff returns the delay before the given retry of a notification.
+func (q *Queue) backoff(attempt int) time.Duration {
+	return notify.Backoff(q.opts.InitialBackoff, q.opts.MaxBackoff, attempt)
+}
@@ -0,0 +1,253 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func testQueueOptions() QueueOptions {
+	return QueueOptions{
+		Capacity:       2,
+		Workers:        1,
+		FullPolicy:     FullPolicyReject,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+		NotifyTimeout:  time.Second,
+	}
+}
+
+// blockingNotify blocks the single worker until release is closed, so that the queue fills up behind it.
+func blockingNotify(started chan<- string, release <-chan struct{}, done *sync.WaitGroup) NotifyFunc {
+	return func(_ context.Context, data *alertmanager.Data) (bool, error) {
+		defer done.Done()
+		started <- data.GroupKey
+		<-release
+		return false, nil
+	}
+}
+
+func TestQueue_FullPolicy(t *testing.T) {
+	for _, tcase := range []struct {
+		policy    FullPolicy
+		err       error
+		processed []string
+	}{
+		{policy: FullPolicyReject, err: ErrQueueFull, processed: []string{"0", "1", "2"}},
+		{policy: FullPolicyDropOldest, processed: []string{"0", "2", "3"}},
+	} {
+		t.Run(string(tcase.policy), func(t *testing.T) {
+			opts := testQueueOptions()
+			opts.FullPolicy = tcase.policy
+			q, err := NewQueue(opts)
+			require.NoError(t, err)
+
+			started, release := make(chan string, 4), make(chan struct{})
+			var done sync.WaitGroup
+			notify := blockingNotify(started, release, &done)
+
+			done.Add(1)
+			require.NoError(t, q.Enqueue(&alertmanager.Data{GroupKey: "0"}, notify))
+			require.Equal(t, "0", <-started)
+
+			done.Add(2)
+			require.NoError(t, q.Enqueue(&alertmanager.Data{GroupKey: "1"}, notify))
+			require.NoError(t, q.Enqueue(&alertmanager.Data{GroupKey: "2"}, notify))
+			err = q.Enqueue(&alertmanager.Data{GroupKey: "3"}, notify)
+			require.Equal(t, tcase.err, err)
+			if err == nil {
+				// "1" was evicted and will never call notify.
+				done.Add(1)
+				done.Done()
+			}
+			require.Equal(t, 2, q.Len())
+
+			close(release)
+			done.Wait()
+			require.NoError(t, q.Close(context.Background()))
+			close(started)
+			processed := []string{"0"}
+			for k := range started {
+				processed = append(processed, k)
+			}
+			require.Equal(t, tcase.processed, processed)
+			require.Equal(t, ErrQueueClosed, q.Enqueue(&alertmanager.Data{}, notify))
+		})
+	}
+}
+
+func TestQueue_Retry(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		failures int
+		retry    bool
+		calls    int
+	}{
+		{name: "succeeds after retries", failures: 2, retry: true, calls: 3},
+		{name: "retries exhausted", failures: 5, retry: true, calls: 3},
+		{name: "not retryable", failures: 5, retry: false, calls: 1},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q, err := NewQueue(testQueueOptions())
+			require.NoError(t, err)
+
+			calls := 0
+			require.NoError(t, q.Enqueue(&alertmanager.Data{}, func(ctx context.Context, _ *alertmanager.Data) (bool, error) {
+				_, hasDeadline := ctx.Deadline()
+				require.True(t, hasDeadline)
+				calls++
+				if calls <= tcase.failures {
+					return tcase.retry, errors.New("jira unavailable")
+				}
+				return false, nil
+			}))
+			require.NoError(t, q.Close(context.Background()))
+			require.Equal(t, tcase.calls, calls)
+		})
+	}
+}
+
+func TestQueue_GroupOrder(t *testing.T) {
+	firing := &alertmanager.Data{Receiver: "jira-ab", GroupKey: "{}:{alertname=\"A\"}", Status: alertmanager.AlertFiring}
+	resolved := &alertmanager.Data{Receiver: "jira-ab", GroupKey: "{}:{alertname=\"A\"}", Status: alertmanager.AlertResolved}
+
+	t.Run("retry superseded", func(t *testing.T) {
+		opts := testQueueOptions()
+		opts.Workers = 2
+		opts.InitialBackoff, opts.MaxBackoff = time.Second, time.Second
+		q, err := NewQueue(opts)
+		require.NoError(t, err)
+
+		var mtx sync.Mutex
+		var applied []string
+		record := func(_ context.Context, data *alertmanager.Data) (bool, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			applied = append(applied, data.Status)
+			return false, nil
+		}
+		failed := make(chan struct{})
+		calls := 0
+		require.NoError(t, q.Enqueue(firing, func(ctx context.Context, data *alertmanager.Data) (bool, error) {
+			if calls++; calls == 1 {
+				close(failed)
+				return true, errors.New("jira unavailable")
+			}
+			return record(ctx, data)
+		}))
+		// The firing notification waits for its retry while the resolved one comes in, and must not be applied after it.
+		<-failed
+		require.NoError(t, q.Enqueue(resolved, record))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, q.Close(ctx))
+		require.Equal(t, []string{alertmanager.AlertResolved}, applied)
+	})
+
+	t.Run("queued replaced", func(t *testing.T) {
+		q, err := NewQueue(testQueueOptions())
+		require.NoError(t, err)
+
+		started, release := make(chan string, 4), make(chan struct{})
+		var done sync.WaitGroup
+		done.Add(1)
+		require.NoError(t, q.Enqueue(&alertmanager.Data{GroupKey: "0"}, blockingNotify(started, release, &done)))
+		require.Equal(t, "0", <-started)
+
+		var applied []string
+		record := func(_ context.Context, data *alertmanager.Data) (bool, error) {
+			applied = append(applied, data.Status)
+			return false, nil
+		}
+		require.NoError(t, q.Enqueue(firing, record))
+		require.NoError(t, q.Enqueue(resolved, record))
+		require.Equal(t, 1, q.Len())
+
+		close(release)
+		done.Wait()
+		require.NoError(t, q.Close(context.Background()))
+		require.Equal(t, []string{alertmanager.AlertResolved}, applied)
+	})
+}
+
+func TestQueue_CloseTimeout(t *testing.T) {
+	q, err := NewQueue(testQueueOptions())
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	require.NoError(t, q.Enqueue(&alertmanager.Data{}, func(ctx context.Context, _ *alertmanager.Data) (bool, error) {
+		close(started)
+		<-ctx.Done()
+		return true, ctx.Err()
+	}))
+	<-started
+	notCalled := func(context.Context, *alertmanager.Data) (bool, error) {
+		t.Error("notification queued behind an aborted shutdown must not be processed")
+		return false, nil
+	}
+	require.NoError(t, q.Enqueue(&alertmanager.Data{}, notCalled))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, q.Close(ctx))
+	require.Equal(t, 0, q.Len())
+}
+
+func TestQueue_Backoff(t *testing.T) {
+	opts := testQueueOptions()
+	opts.InitialBackoff, opts.MaxBackoff = time.Second, 5*time.Second
+	q := &Queue{opts: opts}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		d := q.backoff(attempt + 1)
+		require.GreaterOrEqual(t, d, max/2, "attempt %d", attempt+1)
+		require.LessOrEqual(t, d, max, "attempt %d", attempt+1)
+	}
+}
+
+func TestWebhook_Async(t *testing.T) {
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), testReloadConf))
+	require.NoError(t, err)
+	q, err := NewQueue(testQueueOptions())
+	require.NoError(t, err)
+	// A closed queue rejects the notification before it can reach Jira.
+	require.NoError(t, q.Close(context.Background()))
+
+	h := NewWebhook(reloader, false, q, nil, nil).AlertHandlerFunc()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(`{"receiver":"jira-ab"}`)))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Contains(t, rec.Body.String(), ErrQueueClosed.Error())
+
+	q, err = NewQueue(testQueueOptions())
+	require.NoError(t, err)
+	wh := NewWebhook(reloader, false, q, nil, nil)
+	rec = httptest.NewRecorder()
+	wh.enqueue(rec, &alertmanager.Data{Receiver: "jira-ab"}, 0, func(context.Context, *alertmanager.Data) (bool, error) {
+		return false, nil
+	})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.JSONEq(t, `{"code":202,"msg":"accepted"}`, rec.Body.String())
+	require.NoError(t, q.Close(context.Background()))
+}
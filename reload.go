@@ -0,0 +1,182 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/jiraclient"
+	"github.com/Hoverhuang-er/jiralert/pkg/template"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot is a consistent, immutable view of the configuration, the templates it references and the Jira clients
+// built from it. Handlers fetch a Snapshot once per request, so a reload never changes the configuration under an
+// in-flight notification.
+type Snapshot struct {
+	Config   *config.Config
+	Template *template.Template
+	Clients  *jiraclient.Registry
+}
+
+// Reloader owns the current Snapshot and replaces it atomically whenever the configuration file is reloaded.
+type Reloader struct {
+	configFile string
+
+	// mtx serializes reloads, so that two concurrent triggers cannot race on the result.
+	mtx     sync.Mutex
+	current atomic.Pointer[Snapshot]
+}
+
+// NewReloader loads and validates the given configuration file and its templates. It fails if the initial load fails,
+// as there is no previous snapshot to fall back to.
+func NewReloader(configFile string) (*Reloader, error) {
+	r := &Reloader{configFile: configFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Snapshot returns the currently active configuration and templates.
+func (r *Reloader) Snapshot() *Snapshot {
+	return r.current.Load()
+}
+
+// Config returns the currently active configuration.
+func (r *Reloader) Config() *config.Config {
+	return r.Snapshot().Config
+}
+
+// Reload re-reads the configuration file and its templates. The new snapshot is only swapped in if both are fully
+// valid; otherwise the previous snapshot stays active and the error is returned.
+func (r *Reloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	snap, err := loadSnapshot(r.configFile)
+	if err != nil {
+		config.ConfigLastReloadSuccessful.Set(0)
+		log.Errorf("failed to reload configuration path:%s err:%v", r.configFile, err)
+		return err
+	}
+	if old := r.current.Swap(snap); old != nil {
+		old.Clients.CloseIdleConnections()
+	}
+	snap.Clients.Activate()
+	config.ConfigLastReloadSuccessful.Set(1)
+	config.ConfigLastReloadSuccessTime.Set(float64(time.Now().Unix()))
+	log.Infof("configuration loaded path:%s receivers:%d", r.configFile, len(snap.Config.Receivers))
+	return nil
+}
+
+func loadSnapshot(configFile string) (*Snapshot, error) {
+	conf, _, err := config.LoadFile(configFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load configuration %s", configFile)
+	}
+	tmpl, err := template.LoadTemplate(conf.Template)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load templates %s", conf.Template)
+	}
+	if err := validateTemplates(conf, tmpl); err != nil {
+		return nil, err
+	}
+	clients, err := jiraclient.NewRegistry(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Config: conf, Template: tmpl, Clients: clients}, nil
+}
+
+// validateTemplates checks that every templated receiver field parses against the loaded templates.
+func validateTemplates(conf *config.Config, tmpl *template.Template) error {
+	for _, rc := range conf.Receivers {
+		fields := map[string]string{
+			"project":     rc.Project,
+			"issue_type":  rc.IssueType,
+			"summary":     rc.Summary,
+			"description": rc.Description,
+			"priority":    rc.Priority,
+			"comment":     rc.Comment,
+		}
+		if rc.AutoResolve != nil {
+			fields["auto_resolve.comment"] = rc.AutoResolve.Comment
+		}
+		for i, c := range rc.Components {
+			fields[fmt.Sprintf("components[%d]", i)] = c
+		}
+		for name, text := range fields {
+			if err := tmpl.Validate(text); err != nil {
+				return errors.Wrapf(err, "invalid %s in receiver %q", name, rc.Name)
+			}
+		}
+		if err := validateFieldTemplates(tmpl, rc.Fields); err != nil {
+			return errors.Wrapf(err, "invalid fields in receiver %q", rc.Name)
+		}
+	}
+	return nil
+}
+
+// validateFieldTemplates walks the same structures deepCopyWithTemplate renders in notify.
+func validateFieldTemplates(tmpl *template.Template, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return tmpl.Validate(v.String())
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateFieldTemplates(tmpl, v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if err := validateFieldTemplates(tmpl, k.Interface()); err != nil {
+				return err
+			}
+			if err := validateFieldTemplates(tmpl, v.MapIndex(k).Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReloadHandlerFunc is the HTTP handler for the `/-/reload` endpoint. It triggers a configuration reload and reports
+// whether it succeeded.
+func ReloadHandlerFunc(r *Reloader) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = w.Write([]byte("only POST or PUT allowed"))
+			return
+		}
+		if err := r.Reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testReloadConf = `
+defaults:
+  api_url: https://jiralert.atlassian.net
+  user: jiralert
+  password: 'JIRAlert'
+  issue_type: Bug
+  summary: '{{ template "jira.summary" . }}'
+  description: '{{ template "jira.description" . }}'
+  reopen_state: "To Do"
+  reopen_duration: 0h
+
+template: jiralert.tmpl
+
+receivers:
+  - name: 'jira-ab'
+    project: AB
+`
+
+// writeTestConfig writes conf and the default template into dir and returns the path of the config file.
+func writeTestConfig(t *testing.T, dir, conf string) string {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "jiralert.tmpl"), []byte(defaultTemplate), 0o644))
+	path := filepath.Join(dir, "jiralert.yml")
+	require.NoError(t, os.WriteFile(path, []byte(conf), 0o644))
+	return path
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestConfig(t, dir, testReloadConf)
+
+	r, err := NewReloader(path)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), testutil.ToFloat64(config.ConfigLastReloadSuccessful))
+	old := r.Snapshot()
+	require.Equal(t, "AB", old.Config.Receivers[0].Project)
+
+	// Valid change is picked up.
+	require.NoError(t, os.WriteFile(path, []byte(testReloadConf+"  - name: 'jira-xy'\n    project: XY\n"), 0o644))
+	require.NoError(t, r.Reload())
+	require.Len(t, r.Config().Receivers, 2)
+	// The old snapshot is untouched, so in-flight requests are unaffected.
+	require.Len(t, old.Config.Receivers, 1)
+
+	for _, tcase := range []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{name: "broken yaml", conf: "receivers: [", err: "load configuration"},
+		{name: "no defaults and no receivers", conf: "template: jiralert.tmpl\n", err: "no receivers defined"},
+		{name: "bad summary template", conf: testReloadConf + "  - name: 'jira-xy'\n    project: XY\n    summary: '{{ .Status '\n", err: `invalid summary in receiver "jira-xy"`},
+		{name: "undefined template", conf: testReloadConf + "  - name: 'jira-xy'\n    project: XY\n    summary: '{{ template \"nope\" . }}'\n", err: `undefined template "nope"`},
+		{name: "bad field template", conf: testReloadConf + "  - name: 'jira-xy'\n    project: XY\n    fields:\n      customfield_1: ['{{ end }}']\n", err: `invalid fields in receiver "jira-xy"`},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(tcase.conf), 0o644))
+			err := r.Reload()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tcase.err)
+			require.Equal(t, float64(0), testutil.ToFloat64(config.ConfigLastReloadSuccessful))
+			// Previous configuration is kept.
+			require.Len(t, r.Config().Receivers, 2)
+		})
+	}
+}
+
+func TestReloadHandlerFunc(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestConfig(t, dir, testReloadConf)
+	r, err := NewReloader(path)
+	require.NoError(t, err)
+
+	h := ReloadHandlerFunc(r)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.NoError(t, os.WriteFile(path, []byte("receivers: ["), 0o644))
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Contains(t, rec.Body.String(), "failed to reload config")
+}
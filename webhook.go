@@ -0,0 +1,318 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jiralert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/config"
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// notifyTimeout bounds the Jira round-trip of a synchronous webhook.
+	notifyTimeout = 30 * time.Second
+
+	// Endpoints recorded with journaled notifications, so that they are replayed the way they were accepted.
+	journalHandlerAlert      = "alert"
+	journalHandlerDeprecated = "alert/deprecated"
+)
+
+// Webhook serves the Alertmanager webhook endpoints. With a Queue, notifications are validated, queued and answered
+// with 202; without one, and for receivers in dry-run mode, the Jira round-trip happens before the response is sent.
+// With a Journal, notifications are recorded before they are answered and replayed by Replay if they were not
+// delivered.
+type Webhook struct {
+	reloader      *Reloader
+	hashJiraLabel bool
+	queue         *Queue
+	journal       *Journal
+	locker        notify.Locker
+}
+
+// NewWebhook creates the webhook handlers. queue may be nil for synchronous operation, journal may be nil to not
+// record notifications. locker serializes the handling of each alert group; if nil, it is serialized within the
+// webhook only.
+func NewWebhook(reloader *Reloader, hashJiraLabel bool, queue *Queue, journal *Journal, locker notify.Locker) *Webhook {
+	if locker == nil {
+		locker = notify.NewKeyedMutex()
+	}
+	return &Webhook{reloader: reloader, hashJiraLabel: hashJiraLabel, queue: queue, journal: journal, locker: locker}
+}
+
+// DeprecatedAlertHandlerFunc is the HTTP handler for `/alert/deprecated`, which routes by the payload's receiver.
+func (wh *Webhook) DeprecatedAlertHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), notifyTimeout)
+		defer func() {
+			_ = req.Body.Close()
+			cancel()
+		}()
+		// https://godoc.org/github.com/prometheus/alertmanager/template#Data
+		data := alertmanager.Data{}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to read request body: %v", err))
+			return
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			errorHandler(w, http.StatusBadRequest, fmt.Errorf("failed to parse request body: %v", err))
+			return
+		}
+		rc, err := wh.reloader.Config().ResolveReceiver(ctx, data.Receiver)
+		if err != nil {
+			log.Errorf("receiver config not found: %s", data.Receiver)
+			errorHandler(w, http.StatusOK, err)
+			return
+		}
+		id, err := wh.record(journalHandlerDeprecated, &data)
+		if err != nil {
+			errorHandler(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		// Dry runs are answered with their plan, so they are not queued.
+		if wh.queue != nil && !rc.DryRun {
+			wh.enqueue(w, &data, id, wh.notifyFunc(journalHandlerDeprecated))
+			return
+		}
+		wb, retry, err := wh.notifyByReceiver(ctx, &data)
+		wh.settle(id, retry, err)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if retry {
+				status = http.StatusServiceUnavailable
+			}
+			log.Errorf("send notify error:%s", err.Error())
+			errorResultHandler(w, status, err, wb)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(wb))
+		config.RequestTotal.WithLabelValues(data.Receiver, "200").Inc()
+	}
+}
+
+// AlertHandlerFunc is the HTTP handler for `/alert`.
+func (wh *Webhook) AlertHandlerFunc() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var data alertmanager.Data
+		ctx, cancel := context.WithTimeout(request.Context(), notifyTimeout)
+		defer func() {
+			_ = request.Body.Close()
+			cancel()
+		}()
+		if err := jsoniter.NewDecoder(request.Body).Decode(&data); err != nil {
+			config.RequestTotal.WithLabelValues(data.Receiver, "400").Inc()
+			errorHandler(writer, http.StatusBadRequest, fmt.Errorf("failed to parse request body: %v", err))
+			return
+		}
+		// Reject unknown receivers up front, also in asynchronous mode, since no retry can make them succeed.
+		rc, err := wh.reloader.Config().ResolveReceiver(ctx, data.Receiver)
+		if err != nil {
+			config.RequestTotal.WithLabelValues(data.Receiver, "400").Inc()
+			errorHandler(writer, http.StatusBadRequest, err)
+			return
+		}
+		id, err := wh.record(journalHandlerAlert, &data)
+		if err != nil {
+			errorHandler(writer, http.StatusServiceUnavailable, err)
+			return
+		}
+		// Dry runs are answered with their plan, so they are not queued.
+		if wh.queue != nil && !rc.DryRun {
+			wh.enqueue(writer, &data, id, wh.notifyFunc(journalHandlerAlert))
+			return
+		}
+		resp, err := wh.newIssues(ctx, &data)
+		wh.settle(id, errors.Is(err, ErrRetry), err)
+		if err != nil {
+			log.Errorf("failed to create jira issue: %v", err)
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrRetry):
+				status = http.StatusServiceUnavailable
+			case errors.Is(err, config.ErrUnknownReceiver):
+				// The configuration was reloaded since the receiver was checked.
+				status = http.StatusBadRequest
+			}
+			errorResultHandler(writer, status, err, resp)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+		_, _ = writer.Write([]byte(resp))
+	}
+}
+
+func (wh *Webhook) newIssues(ctx context.Context, data *alertmanager.Data) (string, error) {
+	snap := wh.reloader.Snapshot()
+	je := Jiralert{
+		Input:       data,
+		Config:      snap.Config,
+		Template:    snap.Template,
+		Clients:     snap.Clients,
+		IsHashLable: wh.hashJiraLabel,
+		Locker:      wh.locker,
+	}
+	return je.NewIssues(ctx)
+}
+
+// notifyByReceiver notifies the receiver named in the payload, using the configuration active when it is called, and
+// returns the response body. If notifying fails, the body returned with the error is the *notify.NotifyResult of what
+// was done before the failure.
+func (wh *Webhook) notifyByReceiver(ctx context.Context, data *alertmanager.Data) (string, bool, error) {
+	snap := wh.reloader.Snapshot()
+	conf, err := snap.Config.ResolveReceiver(ctx, data.Receiver)
+	if err != nil {
+		return "", false, err
+	}
+	client, ok := snap.Clients.Client(conf.Name)
+	if !ok {
+		return "", false, errors.Errorf("no Jira client for receiver %s", conf.Name)
+	}
+	receiver := notify.NewReceiver(conf, snap.Template, notify.NewIssueService(client), wh.locker)
+	res, err := receiver.Notify(ctx, data, wh.hashJiraLabel)
+	if err != nil {
+		return failureResponse(res), notify.IsRetryable(err), err
+	}
+	wb, err := notifyResponse(res, receiver)
+	return wb, false, err
+}
+
+// notifyFunc returns how notifications accepted by the given endpoint are delivered to Jira.
+func (wh *Webhook) notifyFunc(handler string) NotifyFunc {
+	if handler == journalHandlerDeprecated {
+		return func(ctx context.Context, data *alertmanager.Data) (bool, error) {
+			_, retry, err := wh.notifyByReceiver(ctx, data)
+			return retry, err
+		}
+	}
+	return func(ctx context.Context, data *alertmanager.Data) (bool, error) {
+		_, err := wh.newIssues(ctx, data)
+		return errors.Is(err, ErrRetry), err
+	}
+}
+
+// record journals a notification before it is answered. Without a journal it does nothing and returns 0.
+func (wh *Webhook) record(handler string, data *alertmanager.Data) (uint64, error) {
+	if wh.journal == nil {
+		return 0, nil
+	}
+	return wh.journal.Append(handler, data)
+}
+
+// settle acks the journal entry of a notification, unless it failed with a retryable error and must be replayed.
+func (wh *Webhook) settle(id uint64, retry bool, err error) {
+	if wh.journal == nil || (err != nil && retry) {
+		return
+	}
+	if err := wh.journal.Ack(id); err != nil {
+		log.Errorf("failed to ack journaled notification id:%d err:%v", id, err)
+	}
+}
+
+// journaled wraps fn so that the journal entry is settled with its outcome.
+func (wh *Webhook) journaled(id uint64, fn NotifyFunc) NotifyFunc {
+	return func(ctx context.Context, data *alertmanager.Data) (bool, error) {
+		retry, err := fn(ctx, data)
+		wh.settle(id, retry, err)
+		return retry, err
+	}
+}
+
+// Replay delivers the notifications a previous run left in the journal, oldest first. In asynchronous mode they are
+// queued, otherwise they are notified one at a time. Notifications failing with a retryable error stay journaled.
+func (wh *Webhook) Replay(ctx context.Context) {
+	if wh.journal == nil {
+		return
+	}
+	entries := wh.journal.Pending()
+	if len(entries) == 0 {
+		return
+	}
+	log.Infof("replaying journaled notifications count:%d", len(entries))
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		// A newer notification of the same group may have been delivered since Replay started.
+		if !wh.journal.IsPending(e.ID) {
+			continue
+		}
+		config.JournalReplayed.Inc()
+		fn := wh.journaled(e.ID, wh.notifyFunc(e.Handler))
+		if wh.queue != nil {
+			if err := wh.queue.Enqueue(e.Data, fn); err != nil {
+				log.Errorf("failed to queue journaled notification id:%d receiver:%s err:%v", e.ID, e.Data.Receiver, err)
+			}
+			continue
+		}
+		nctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		if _, err := fn(nctx, e.Data); err != nil {
+			log.Errorf("failed to replay journaled notification id:%d receiver:%s err:%v", e.ID, e.Data.Receiver, err)
+		}
+		cancel()
+	}
+}
+
+func (wh *Webhook) enqueue(w http.ResponseWriter, data *alertmanager.Data, id uint64, fn NotifyFunc) {
+	if err := wh.queue.Enqueue(data, wh.journaled(id, fn)); err != nil {
+		// Alertmanager retries on 503, so the notification does not need to be replayed.
+		wh.settle(id, false, nil)
+		errorHandler(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	wb, _ := jsoniter.MarshalToString(map[string]interface{}{
+		"code": http.StatusAccepted,
+		"msg":  "accepted",
+	})
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte(wb))
+	config.RequestTotal.WithLabelValues(data.Receiver, "202").Inc()
+}
+
+func errorHandler(w http.ResponseWriter, status int, err error) {
+	errorResultHandler(w, status, err, "")
+}
+
+// errorResultHandler answers a notification that failed with err, along with result, the JSON of what was done before
+// the failure, if not empty.
+func errorResultHandler(w http.ResponseWriter, status int, err error, result string) {
+	w.WriteHeader(status)
+	response := struct {
+		Error   bool
+		Status  int
+		Message string
+		Result  json.RawMessage `json:",omitempty"`
+	}{
+		true,
+		status,
+		err.Error(),
+		json.RawMessage(result),
+	}
+	// JSON response
+	bytes, _ := json.Marshal(response)
+	json := string(bytes[:])
+	fmt.Fprint(w, json)
+	log.Error("msg", "error handling request", "statusCode", status, "statusText", http.StatusText(status),
+		"err", err)
+}
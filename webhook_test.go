@@ -0,0 +1,388 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jiralert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Hoverhuang-er/jiralert/pkg/alertmanager"
+	"github.com/Hoverhuang-er/jiralert/pkg/notify"
+	"github.com/stretchr/testify/require"
+)
+
+// testJira is a minimal Jira stand-in keeping issues in memory. Every workflow has the transitions "Done", into the
+// done status category, and "To Do", back out of it.
+type testJira struct {
+	*httptest.Server
+
+	// failWith is the status every request is answered with, or 0 to work normally.
+	failWith atomic.Int32
+	// failUpdates is the status updates of issues are answered with, or 0 to update them.
+	failUpdates atomic.Int32
+
+	mtx     sync.Mutex
+	issues  []*testIssue
+	created []string
+}
+
+type testIssue struct {
+	Key         string
+	Project     string
+	Label       string
+	Summary     string
+	Description string
+	// Status is the key of the status category, "new" or "done".
+	Status string
+	Fields map[string]json.RawMessage
+}
+
+var (
+	testJQL         = regexp.MustCompile(`^project="([^"]*)" and labels=("(?:[^"\\]|\\.)*")`)
+	testIssuePath   = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)$`)
+	testTransitions = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)/transitions$`)
+)
+
+func newTestJira(t *testing.T) *testJira {
+	j := &testJira{}
+	j.Server = httptest.NewServer(http.HandlerFunc(j.serveHTTP))
+	t.Cleanup(j.Close)
+	return j
+}
+
+func (j *testJira) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if status := j.failWith.Load(); status != 0 {
+		w.WriteHeader(int(status))
+		return
+	}
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	var body struct {
+		Fields     map[string]json.RawMessage `json:"fields"`
+		Transition struct {
+			ID string `json:"id"`
+		} `json:"transition"`
+	}
+	if r.Method != http.MethodGet {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/rest/api/2/search":
+		m := testJQL.FindStringSubmatch(r.URL.Query().Get("jql"))
+		if m == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		label, _ := strconv.Unquote(m[2])
+		type issue struct {
+			Key    string                 `json:"key"`
+			Fields map[string]interface{} `json:"fields"`
+		}
+		found := []issue{}
+		for i := len(j.issues) - 1; i >= 0; i-- {
+			if is := j.issues[i]; is.Project == m[1] && is.Label == label {
+				found = append(found, issue{Key: is.Key, Fields: map[string]interface{}{
+					"summary": is.Summary,
+					"status":  map[string]interface{}{"statusCategory": map[string]string{"key": is.Status}},
+				}})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"issues": found})
+	case r.Method == http.MethodPost && r.URL.Path == "/rest/api/2/issue":
+		var project struct {
+			Key string `json:"key"`
+		}
+		var labels []string
+		is := &testIssue{Status: "new", Fields: body.Fields}
+		_ = json.Unmarshal(body.Fields["project"], &project)
+		_ = json.Unmarshal(body.Fields["summary"], &is.Summary)
+		_ = json.Unmarshal(body.Fields["description"], &is.Description)
+		_ = json.Unmarshal(body.Fields["labels"], &labels)
+		is.Project, is.Key = project.Key, fmt.Sprintf("%s-%d", project.Key, len(j.issues)+1)
+		if len(labels) > 0 {
+			is.Label = labels[0]
+		}
+		j.issues = append(j.issues, is)
+		j.created = append(j.created, is.Project)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"key":%q}`, is.Key)
+	case r.Method == http.MethodPut && testIssuePath.MatchString(r.URL.Path):
+		if status := j.failUpdates.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		is := j.issue(testIssuePath.FindStringSubmatch(r.URL.Path)[1])
+		if is == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range body.Fields {
+			is.Fields[k] = v
+		}
+		_ = json.Unmarshal(body.Fields["summary"], &is.Summary)
+		_ = json.Unmarshal(body.Fields["description"], &is.Description)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && testTransitions.MatchString(r.URL.Path):
+		_, _ = w.Write([]byte(`{"transitions":[{"id":"1","name":"Done"},{"id":"2","name":"To Do"}]}`))
+	case r.Method == http.MethodPost && testTransitions.MatchString(r.URL.Path):
+		is := j.issue(testTransitions.FindStringSubmatch(r.URL.Path)[1])
+		if is == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		is.Status = map[string]string{"1": "done", "2": "new"}[body.Transition.ID]
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// issue must be called with j.mtx held.
+func (j *testJira) issue(key string) *testIssue {
+	for _, is := range j.issues {
+		if is.Key == key {
+			return is
+		}
+	}
+	return nil
+}
+
+// Issue returns a copy of the issue with the given key, or nil.
+func (j *testJira) Issue(key string) *testIssue {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if is := j.issue(key); is != nil {
+		c := *is
+		return &c
+	}
+	return nil
+}
+
+// Created returns the projects of the issues created since the last call.
+func (j *testJira) Created() []string {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	created := j.created
+	j.created = nil
+	return created
+}
+
+// config returns testReloadConf pointing at the stand-in, followed by extra.
+func (j *testJira) config(extra string) string {
+	return strings.Replace(testReloadConf, "https://jiralert.atlassian.net", j.URL, 1) + extra
+}
+
+func testAlertBody(receiver string) string {
+	return fmt.Sprintf(`{"receiver":%q,"groupKey":"{}:{alertname=\"A\"}","status":"firing",`+
+		`"alerts":[{"status":"firing","labels":{"alertname":"A"}}],"groupLabels":{"alertname":"A"}}`, receiver)
+}
+
+func TestWebhook_Routing(t *testing.T) {
+	receivers := `
+  - name: 'jira-xy'
+    project: XY
+`
+	for _, tcase := range []struct {
+		name     string
+		extra    string
+		path     string
+		receiver string
+		// payload defaults to an alert for receiver.
+		payload string
+		code    int
+		body    string
+		created []string
+	}{
+		{name: "first receiver", path: "/alert", receiver: "jira-ab", code: http.StatusOK, created: []string{"AB"}},
+		{name: "second receiver", path: "/alert", receiver: "jira-xy", code: http.StatusOK, created: []string{"XY"}},
+		{
+			name: "unknown receiver", path: "/alert", receiver: "jira-nope", code: http.StatusBadRequest,
+			body: `unknown receiver \"jira-nope\": not in the configuration and no fallback_receiver is set`,
+		},
+		{
+			name: "fallback receiver", extra: "fallback_receiver: jira-xy\n", path: "/alert", receiver: "jira-nope",
+			code: http.StatusOK, created: []string{"XY"},
+		},
+		{
+			name: "malformed payload", path: "/alert", payload: `{"receiver":`, code: http.StatusBadRequest,
+			body: "failed to parse request body",
+		},
+		{name: "deprecated second receiver", path: "/alert/deprecated", receiver: "jira-xy", code: http.StatusOK, created: []string{"XY"}},
+		{
+			// Kept answering 200 for backwards compatibility.
+			name: "deprecated unknown receiver", path: "/alert/deprecated", receiver: "jira-nope", code: http.StatusOK,
+			body: `unknown receiver \"jira-nope\"`,
+		},
+		{
+			name: "deprecated fallback receiver", extra: "fallback_receiver: jira-xy\n", path: "/alert/deprecated",
+			receiver: "jira-nope", code: http.StatusOK, created: []string{"XY"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			jira := newTestJira(t)
+			reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config(receivers+tcase.extra)))
+			require.NoError(t, err)
+			wh := NewWebhook(reloader, true, nil, nil, nil)
+			mux := http.NewServeMux()
+			mux.HandleFunc("/alert", wh.AlertHandlerFunc())
+			mux.HandleFunc("/alert/deprecated", wh.DeprecatedAlertHandlerFunc())
+
+			payload := tcase.payload
+			if payload == "" {
+				payload = testAlertBody(tcase.receiver)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tcase.path, strings.NewReader(payload)))
+			require.Equal(t, tcase.code, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tcase.body)
+			require.Equal(t, tcase.created, jira.Created())
+		})
+	}
+}
+
+func TestWebhook_NotifyResult(t *testing.T) {
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config("")))
+	require.NoError(t, err)
+	wh := NewWebhook(reloader, true, nil, nil, nil)
+
+	for _, tcase := range []struct {
+		handler http.HandlerFunc
+		resp    string
+	}{
+		{
+			handler: wh.AlertHandlerFunc(),
+			resp: fmt.Sprintf(`{"code":200,"msg":"success","issue_key":"AB-1","issue_url":"%s/browse/AB-1","action":"created",`+
+				`"api_calls":["Issue.Search","Issue.Create"]}`, jira.URL),
+		},
+		{
+			// The description is not searched for, so it is always written again.
+			handler: wh.DeprecatedAlertHandlerFunc(),
+			resp: fmt.Sprintf(`{"code":200,"msg":"success","issue_key":"AB-1","issue_url":"%s/browse/AB-1","action":"updated",`+
+				`"api_calls":["Issue.Search","Issue.UpdateWithOptions"]}`, jira.URL),
+		},
+	} {
+		rec := httptest.NewRecorder()
+		tcase.handler(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-ab"))))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.JSONEq(t, tcase.resp, rec.Body.String())
+	}
+}
+
+func TestWebhook_NotifyFailure(t *testing.T) {
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config("")))
+	require.NoError(t, err)
+	wh := NewWebhook(reloader, true, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	wh.AlertHandlerFunc()(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-ab"))))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// The issue found before the update failed is part of the answer.
+	jira.failUpdates.Store(http.StatusBadRequest)
+	rec = httptest.NewRecorder()
+	wh.DeprecatedAlertHandlerFunc()(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-ab"))))
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	var resp struct {
+		Status int
+		Result *notify.NotifyResult
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusInternalServerError, resp.Status)
+	require.Equal(t, &notify.NotifyResult{
+		IssueKey: "AB-1",
+		IssueURL: jira.URL + "/browse/AB-1",
+		Action:   notify.ActionNoop,
+		APICalls: []string{"Issue.Search", "Issue.UpdateWithOptions"},
+	}, resp.Result)
+
+	// Failures worth retrying are ErrRetry, without losing what they are.
+	jira.failUpdates.Store(http.StatusServiceUnavailable)
+	var data alertmanager.Data
+	require.NoError(t, json.Unmarshal([]byte(testAlertBody("jira-ab")), &data))
+	body, err := wh.newIssues(context.Background(), &data)
+	require.ErrorIs(t, err, ErrRetry)
+	var retryable *notify.RetryableError
+	require.ErrorAs(t, err, &retryable)
+	require.Contains(t, body, `"issue_key":"AB-1"`)
+}
+
+func TestWebhook_DryRun(t *testing.T) {
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config(`
+  - name: 'jira-xy'
+    project: XY
+    dry_run: true
+`)))
+	require.NoError(t, err)
+	q, err := NewQueue(testQueueOptions())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, q.Close(context.Background())) }()
+
+	// Dry runs are answered with their plan also in asynchronous mode.
+	for _, wh := range []*Webhook{NewWebhook(reloader, true, nil, nil, nil), NewWebhook(reloader, true, q, nil, nil)} {
+		for _, h := range []http.HandlerFunc{wh.AlertHandlerFunc(), wh.DeprecatedAlertHandlerFunc()} {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-xy"))))
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			var resp struct {
+				DryRun         bool `json:"dry_run"`
+				PlannedActions []struct {
+					Operation string `json:"operation"`
+					Issue     struct {
+						Fields map[string]json.RawMessage `json:"fields"`
+					} `json:"issue"`
+				} `json:"planned_actions"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			require.True(t, resp.DryRun)
+			require.Len(t, resp.PlannedActions, 1)
+			require.Equal(t, "create", resp.PlannedActions[0].Operation)
+			require.JSONEq(t, `{"key":"XY"}`, string(resp.PlannedActions[0].Issue.Fields["project"]))
+		}
+	}
+	require.Empty(t, jira.Created())
+}
+
+func TestWebhook_RoutingAsync(t *testing.T) {
+	jira := newTestJira(t)
+	reloader, err := NewReloader(writeTestConfig(t, t.TempDir(), jira.config("")))
+	require.NoError(t, err)
+	q, err := NewQueue(testQueueOptions())
+	require.NoError(t, err)
+	h := NewWebhook(reloader, true, q, nil, nil).AlertHandlerFunc()
+
+	// Unknown receivers are rejected before they are queued.
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-nope"))))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/alert", strings.NewReader(testAlertBody("jira-ab"))))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.NoError(t, q.Close(context.Background()))
+	require.Equal(t, []string{"AB"}, jira.Created())
+}